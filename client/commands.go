@@ -0,0 +1,218 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "os"
+  "strings"
+
+  "github.com/spf13/pflag"
+
+  "github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+)
+
+// Version Version of the client binary. It is set at build time with
+// -ldflags "-X main.Version=<version>"
+var Version = "dev"
+
+// Command A subcommand of the client binary. Run receives the context that
+// is cancelled on SIGTERM/SIGINT and returns the exit status of the process
+type Command struct {
+  Name        string
+  Description string
+  Run         func(ctx context.Context, client *common.Client) int
+}
+
+// Commands Subcommands supported by the client. When no subcommand is given
+// the client runs "run", which uploads the bets and then polls the winners
+var Commands = []Command{
+  {"run", "upload the bets and then poll the winners (default)", RunCommand},
+  {"upload", "upload the bets and send the finish frame, without polling", UploadCommand},
+  {"watch", "upload every bets file dropped into watch.dir, until stopped", WatchCommand},
+  {"poll", "poll the lottery center until the winners are available", PollCommand},
+  {"validate", "validate the bets file without connecting to the server", ValidateCommand},
+  {"status", "show the saved progress of the upload", StatusCommand},
+  {"version", "print the client version", nil},
+}
+
+// ConfigFlags Command line flags that override the configuration. Each flag
+// is bound to the viper key with the same meaning, so a flag takes precedence
+// over both the environment variables and the config file
+var ConfigFlags = map[string]string{
+  "id":               "id",
+  "server-address":   "server.address",
+  "loop-period":      "loop.period",
+  "loop-lapse":       "loop.lapse",
+  "log-level":        "log.level",
+  "log-format":       "log.format",
+  "log-redact":       "log.redact",
+  "bets-file":        "bets.file",
+  "batch-size":       "bets.batch_size",
+  "batch-max-bytes":  "bets.batch_max_bytes",
+  "parallel":         "bets.parallel",
+  "progress-file":    "bets.progress_file",
+  "rate-limit-bets":  "bets.rate_limit.bets_per_second",
+  "rate-limit-bytes": "bets.rate_limit.bytes_per_second",
+  "dry-run":          "dry_run.enabled",
+  "dry-run-output":   "dry_run.output",
+  "winners-output":   "winners.output",
+  "winners-format":   "winners.format",
+  "verify-winners":   "winners.verify",
+  "winning-number":   "winners.winning_number",
+  "metrics-address":  "metrics.address",
+  "spool-dir":        "spool.dir",
+  "watch-dir":        "watch.dir",
+  "watch-settle":     "watch.settle",
+  "finish-on-exit":   "watch.finish_on_exit",
+}
+
+// ParseCommand Returns the subcommand named by the first argument and the
+// remaining arguments. If the first argument is a flag (or there are no
+// arguments) the default "run" subcommand is returned
+func ParseCommand(args []string) (*Command, []string, error) {
+  name := "run"
+  if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+    name, args = args[0], args[1:]
+  }
+
+  for i := range Commands {
+    if Commands[i].Name == name {
+      return &Commands[i], args, nil
+    }
+  }
+  return nil, nil, fmt.Errorf("unknown command %q", name)
+}
+
+// NewFlagSet Creates the flag set of a subcommand with the config override
+// flags
+func NewFlagSet(command string) *pflag.FlagSet {
+  flags := pflag.NewFlagSet(command, pflag.ContinueOnError)
+  flags.String("config", "./config.yaml", "configuration file")
+  flags.String("id", "", "agency number")
+  flags.String("server-address", "", "lottery center addresses (host:port, tcp://host:port or unix:///path), comma separated in order of preference")
+  flags.Duration("loop-period", 0, "time between scans of the bets file for new bets")
+  flags.Duration("loop-lapse", 0, "time during which the bets file is scanned for new bets (0 uploads it once)")
+  flags.String("log-level", "", "log level")
+  flags.String("log-format", "", "log format: text or json")
+  flags.String("log-redact", "", "personal data masked in the logs: all, none or a list of document, name and birthdate")
+  flags.String("bets-file", "", "csv file with the agency bets")
+  flags.Uint("batch-size", 0, "maximum number of bets per batch")
+  flags.Uint("batch-max-bytes", 0, "maximum size in bytes of a serialized batch")
+  flags.Uint("parallel", 0, "number of parallel connections used to upload the bets")
+  flags.String("progress-file", "", "file where the upload progress is saved")
+  flags.Uint("rate-limit-bets", 0, "maximum upload rate in bets per second (0 for no limit)")
+  flags.Uint("rate-limit-bytes", 0, "maximum upload rate in bytes per second (0 for no limit)")
+  flags.Bool("dry-run", false, "write the frames to --dry-run-output instead of sending them to the server")
+  flags.String("dry-run-output", "", "file where the dry run frames are written ('-' for stdout)")
+  flags.String("winners-output", "", "file where the winners are exported ('-' for stdout)")
+  flags.String("winners-format", "", "format of the exported winners: csv, json or ndjson")
+  flags.Bool("verify-winners", true, "check the winners received against the bets file")
+  flags.Int("winning-number", 0, "number that wins the lottery, used to verify the winners")
+  flags.String("metrics-address", "", "address where the Prometheus metrics are served (host:port)")
+  flags.String("spool-dir", "", "directory where the bets are stored until the lottery center confirms them")
+  flags.String("watch-dir", "", "directory watched for new bets files (watch command)")
+  flags.Duration("watch-settle", 0, "time a file must stay unmodified to be considered complete (watch command)")
+  flags.Bool("finish-on-exit", false, "send the finish frame when the watch command is stopped")
+
+  flags.Usage = func() {
+    fmt.Fprintf(os.Stderr, "usage: client [command] [flags]\n\ncommands:\n")
+    for _, c := range Commands {
+      fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.Name, c.Description)
+    }
+    fmt.Fprintf(os.Stderr, "\nflags:\n%s", flags.FlagUsages())
+  }
+  return flags
+}
+
+// ExitStatusFor Returns the exit status for the result of a command
+func ExitStatusFor(err error) int {
+  if err == nil {
+    return ExitSuccess
+  }
+  if sig := ReceivedSignal(); sig != nil {
+    common.LogAction("shutdown", "success").WithField("signal", sig.String()).Info()
+    return ExitStatus(sig)
+  }
+  if err == common.ErrWinnersMismatch {
+    return ExitMismatch
+  }
+  return ExitFailure
+}
+
+// RunCommand Uploads the bets and then polls the winners
+func RunCommand(ctx context.Context, client *common.Client) int {
+  return ExitStatusFor(client.Run(ctx))
+}
+
+// UploadCommand Uploads the bets and sends the finish frame
+func UploadCommand(ctx context.Context, client *common.Client) int {
+  return ExitStatusFor(client.Upload(ctx))
+}
+
+// WatchCommand Uploads every bets file dropped into the watched directory
+// until the client is stopped
+func WatchCommand(ctx context.Context, client *common.Client) int {
+  return ExitStatusFor(client.Watch(ctx))
+}
+
+// PollCommand Polls the lottery center until the winners are available
+func PollCommand(ctx context.Context, client *common.Client) int {
+  _, err := client.Poll(ctx)
+  return ExitStatusFor(err)
+}
+
+// ValidateCommand Validates every bet of the bets file and prints a report.
+// The exit status is 1 if the file has invalid bets
+func ValidateCommand(ctx context.Context, client *common.Client) int {
+  report, err := client.Validate()
+  if err != nil {
+    common.LogAction("validate", "fail").WithField("file", report.File).WithError(err).Error()
+    return ExitFailure
+  }
+
+  fmt.Printf("file:    %v\n", report.File)
+  fmt.Printf("bets:    %v\n", report.Bets)
+  fmt.Printf("valid:   %v\n", report.Bets-report.Invalid)
+  fmt.Printf("invalid: %v\n", report.Invalid)
+  for _, problem := range report.Problems {
+    fmt.Printf("  line %v: %v\n", problem.Line, problem.Err)
+  }
+  if report.Invalid > len(report.Problems) {
+    fmt.Printf("  ... %v more\n", report.Invalid-len(report.Problems))
+  }
+
+  if report.Invalid > 0 {
+    return ExitFailure
+  }
+  return ExitSuccess
+}
+
+// StatusCommand Prints the saved progress of the upload
+func StatusCommand(ctx context.Context, client *common.Client) int {
+  status, err := client.Status()
+  if err != nil {
+    common.LogAction("status", "fail").WithField("file", status.ProgressFile).WithError(err).Error()
+    return ExitFailure
+  }
+
+  fmt.Printf("file:          %v\n", status.File)
+  fmt.Printf("progress file: %v\n", status.ProgressFile)
+  switch {
+  case status.Finished:
+    fmt.Printf("state:         uploaded, waiting for winners\n")
+  case status.Started:
+    fmt.Printf("state:         upload interrupted\n")
+  default:
+    fmt.Printf("state:         not started\n")
+  }
+
+  for i, shard := range status.Shards {
+    percentage := 100.0
+    if shard.Bets > 0 {
+      percentage = 100 * float64(shard.Confirmed) / float64(shard.Bets)
+    }
+    fmt.Printf("  shard %v: bytes [%v, %v) | confirmed %v/%v bets (%.1f%%)\n",
+      i, shard.Start, shard.End, shard.Confirmed, shard.Bets, percentage)
+  }
+  return ExitSuccess
+}
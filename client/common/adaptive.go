@@ -0,0 +1,99 @@
+package common
+
+import (
+    "time"
+
+    log "github.com/sirupsen/logrus"
+)
+
+// AdaptiveConfig configura el ajuste automatico del tamaño de los batches
+type AdaptiveConfig struct {
+    Enabled       bool
+    TargetLatency time.Duration
+    MinBatchSize  uint
+    MaxBatchSize  uint
+    Increase      uint
+    Decrease      float64
+}
+
+// batchSizer decide cuantas apuestas lleva el proximo batch
+//
+// Con el modo adaptativo desactivado el tamaño es siempre el configurado.
+// Con el modo activado se ajusta de forma AIMD segun el tiempo entre el
+//  envio de un batch y su confirmacion:
+//  * Si la confirmacion llego dentro de la latencia objetivo el tamaño
+//      crece sumando Increase apuestas
+//  * Si tardo mas, el tamaño se multiplica por Decrease
+// Siempre dentro de los limites [MinBatchSize, MaxBatchSize]
+type batchSizer struct {
+    config AdaptiveConfig
+    size   uint
+}
+
+// Crea un batchSizer que comienza con el tamaño initial
+func newBatchSizer(initial uint, config AdaptiveConfig) *batchSizer {
+    sizer := &batchSizer{config: config, size: initial}
+    if config.Enabled {
+        sizer.size = sizer.clamp(initial)
+    }
+    return sizer
+}
+
+// Aplica una nueva configuracion recargada mientras el cliente corre.
+// Activar o desactivar el modo adaptativo no puede cambiarse en caliente:
+//  sin el modo adaptativo el tamaño pasa a ser batchSize, y con el modo
+//  activado se toman los nuevos parametros y el tamaño actual se ajusta
+//  a los nuevos limites
+func (s *batchSizer) update(batchSize uint, config AdaptiveConfig) {
+    config.Enabled = s.config.Enabled
+    s.config = config
+    if !s.config.Enabled {
+        s.size = batchSize
+        return
+    }
+    s.size = s.clamp(s.size)
+}
+
+// Devuelve el tamaño del proximo batch
+func (s *batchSizer) Size() uint {
+    return s.size
+}
+
+// Registra el tiempo que tardo en confirmarse el ultimo batch
+//  y ajusta el tamaño del proximo
+func (s *batchSizer) Observe(rtt time.Duration) {
+    if !s.config.Enabled {
+        return
+    }
+
+    previous := s.size
+    if rtt <= s.config.TargetLatency {
+        s.size = s.clamp(s.size + s.config.Increase)
+    } else {
+        s.size = s.clamp(uint(float64(s.size) * s.config.Decrease))
+    }
+
+    entry := logAction("adaptive_batch_size", "success").WithFields(log.Fields{
+        "rtt":        rtt.String(),
+        "target":     s.config.TargetLatency.String(),
+        "batch_size": s.size,
+    })
+    if s.size != previous {
+        entry.WithField("previous_batch_size", previous).Info()
+    } else {
+        entry.Debug()
+    }
+}
+
+func (s *batchSizer) clamp(size uint) uint {
+    if size < s.config.MinBatchSize {
+        size = s.config.MinBatchSize
+    }
+    if s.config.MaxBatchSize > 0 && size > s.config.MaxBatchSize {
+        size = s.config.MaxBatchSize
+    }
+    if size == 0 {
+        size = 1
+    }
+    return size
+}
@@ -0,0 +1,110 @@
+package common
+
+import (
+    "errors"
+    "strconv"
+)
+
+// Numero ganador del sorteo, el mismo que usa el servidor
+const LOTTERY_WINNER_NUMBER = 7574
+
+// ErrWinnersMismatch indica que los ganadores recibidos no coinciden
+//  con los que se esperaban segun el archivo de apuestas de la agencia
+var ErrWinnersMismatch = errors.New("winners do not match the agency bets")
+
+// AuditReport es el resultado de contrastar los ganadores recibidos
+//  del servidor con el archivo de apuestas de la agencia
+type AuditReport struct {
+    Received   int
+    // Apuestas ganadoras del archivo
+    Expected   int
+    // Documentos ganadores que la agencia nunca envio
+    Unknown    []string
+    // Documentos que el servidor informo menos veces que sus apuestas
+    //  ganadoras en el archivo
+    Missing    []string
+    // Documentos que la agencia envio pero sin el numero ganador
+    Unexpected []string
+    // Documentos que el servidor informo mas veces que sus apuestas
+    //  ganadoras en el archivo. La subida es at-least-once, por lo que un
+    //  batch reenviado al retomar o al cambiar de central puede haber
+    //  quedado registrado dos veces: se informan pero no son un error
+    Duplicates []string
+}
+
+// Indica si los ganadores recibidos coinciden con los esperados
+func (r AuditReport) Ok() bool {
+    return len(r.Unknown) == 0 && len(r.Missing) == 0 && len(r.Unexpected) == 0
+}
+
+// Indica si bet tiene el numero ganador: WinningNumber o
+//  LOTTERY_WINNER_NUMBER si no esta configurado
+func (c *Client) isWinningBet(bet Bet) bool {
+    winningNumber := c.config.WinningNumber
+    if winningNumber == 0 {
+        winningNumber = LOTTERY_WINNER_NUMBER
+    }
+
+    number, err := strconv.Atoi(bet.Number)
+    return err == nil && number == winningNumber
+}
+
+// Verifica que documents sean exactamente los ganadores que se esperan
+//  segun el archivo de apuestas: las apuestas cuyo numero es WinningNumber
+//  (LOTTERY_WINNER_NUMBER si no esta configurado).
+// El servidor informa un ganador por apuesta, por lo que un documento con
+//  varias apuestas ganadoras en el archivo debe recibirse esa cantidad de
+//  veces. Solo se devuelve error si el archivo no pudo leerse; las
+//  diferencias se informan en el reporte
+func (c *Client) AuditWinners(documents []string) (AuditReport, error) {
+    report := AuditReport{Received: len(documents)}
+
+    received := make(map[string]int, len(documents))
+    for _, document := range documents {
+        received[document]++
+    }
+
+    submitted := make(map[string]bool, len(received))
+    // Apuestas ganadoras de cada documento, en el orden del archivo
+    expected := make(map[string]int)
+    winning := []string{}
+    err := scanBets(c.config.BetsFile, func(bet Bet) {
+        if _, ok := received[bet.Document]; ok {
+            submitted[bet.Document] = true
+        }
+
+        if c.isWinningBet(bet) {
+            if expected[bet.Document] == 0 {
+                winning = append(winning, bet.Document)
+            }
+            expected[bet.Document]++
+            report.Expected++
+        }
+    })
+    if err != nil {
+        return report, err
+    }
+
+    for _, document := range winning {
+        if received[document] < expected[document] {
+            report.Missing = append(report.Missing, document)
+        }
+    }
+
+    reported := make(map[string]bool, len(received))
+    for _, document := range documents {
+        if reported[document] {
+            continue
+        }
+        reported[document] = true
+
+        if !submitted[document] {
+            report.Unknown = append(report.Unknown, document)
+        } else if expected[document] == 0 {
+            report.Unexpected = append(report.Unexpected, document)
+        } else if received[document] > expected[document] {
+            report.Duplicates = append(report.Duplicates, document)
+        }
+    }
+    return report, nil
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestAuditWinnersCountsWinningBets(t *testing.T) {
+    path := writeBetsFile(t, ""+
+        "A,B,111,1999-03-17,7574\n"+
+        "A,B,111,1999-03-17,7574\n"+
+        "C,D,222,1999-03-17,7574\n"+
+        "E,F,333,1999-03-17,7574\n"+
+        "G,H,444,1999-03-17,1\n")
+    client := NewClient(ClientConfig{ID: "1", BetsFile: path})
+
+    cases := []struct {
+        name     string
+        received []string
+        ok       bool
+        report   AuditReport
+    }{
+        {
+            // Dos apuestas ganadoras con el mismo documento no son un error
+            name:     "repeated winning document",
+            received: []string{"111", "111", "222", "333"},
+            ok:       true,
+            report:   AuditReport{Received: 4, Expected: 4},
+        },
+        {
+            // Un batch reenviado se informa pero no es un error
+            name:     "resent bet",
+            received: []string{"111", "111", "222", "222", "333"},
+            ok:       true,
+            report:   AuditReport{Received: 5, Expected: 4, Duplicates: []string{"222"}},
+        },
+        {
+            name:     "fewer than the winning bets",
+            received: []string{"111", "222", "333"},
+            report:   AuditReport{Received: 3, Expected: 4, Missing: []string{"111"}},
+        },
+        {
+            name:     "unknown and unexpected",
+            received: []string{"111", "111", "222", "333", "444", "555"},
+            report:   AuditReport{Received: 6, Expected: 4, Unknown: []string{"555"}, Unexpected: []string{"444"}},
+        },
+    }
+
+    for _, c := range cases {
+        report, err := client.AuditWinners(c.received)
+        if err != nil {
+            t.Fatalf("%s: %v", c.name, err)
+        }
+        if report.Ok() != c.ok || !reflect.DeepEqual(report, c.report) {
+            t.Errorf("%s: got %+v (ok %v), want %+v (ok %v)", c.name, report, report.Ok(), c.report, c.ok)
+        }
+    }
+}
+
+func TestJoinWinnersUsesWinningBet(t *testing.T) {
+    // La apuesta perdedora del mismo documento aparece despues de la ganadora
+    path := writeBetsFile(t, ""+
+        "Ana,Lopez,111,1990-01-01,7574\n"+
+        "Ana,Lopez,111,1990-01-01,1234\n"+
+        "Juan,Perez,222,1985-05-05,1\n"+
+        "Juan,Perez,222,1985-05-05,7574\n"+
+        "Juan,Perez,222,1985-05-06,7574\n")
+    client := NewClient(ClientConfig{ID: "1", BetsFile: path})
+
+    winners, err := client.joinWinners([]string{"111", "222", "222", "333"})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    want := []Winner{
+        {Agency: "1", Document: "111", Name: "Ana", Surname: "Lopez", BirthDate: "1990-01-01", Number: "7574"},
+        {Agency: "1", Document: "222", Name: "Juan", Surname: "Perez", BirthDate: "1985-05-05", Number: "7574"},
+        {Agency: "1", Document: "222", Name: "Juan", Surname: "Perez", BirthDate: "1985-05-06", Number: "7574"},
+        {Agency: "1", Document: "333"},
+    }
+    if !reflect.DeepEqual(winners, want) {
+        t.Errorf("got %+v, want %+v", winners, want)
+    }
+}
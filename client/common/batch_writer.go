@@ -0,0 +1,159 @@
+package common
+
+import (
+    "fmt"
+    "io"
+)
+
+// batchWriter envia un batch apuesta por apuesta: primero el
+//  encabezado 'Z' con la cantidad y luego cada apuesta a medida que
+//  se escribe, sin juntar el batch completo en memoria
+type batchWriter struct {
+    center    *NationalLotteryCenter
+    remaining int
+}
+
+// Comienza un batch de count apuestas escribiendo su encabezado.
+// Las apuestas se escriben con Write y el batch se termina con Close,
+//  todo dentro de la espera maxima de una operacion (ver ServerTimeouts)
+func (p *NationalLotteryCenter) beginBatch(count int) (*batchWriter, error) {
+    p.armDeadline()
+    err := writeHeader(p.writer, BATCH_TYPE, count)
+    if err != nil {
+        return nil, err
+    }
+
+    return &batchWriter{center: p, remaining: count}, nil
+}
+
+// Escribe una apuesta del batch sobre el buffer de la conexion.
+// Cuando el buffer se llena los datos se envian, por lo que las
+//  primeras apuestas viajan mientras se leen las siguientes
+func (b *batchWriter) Write(bet Bet) error {
+    if b.remaining == 0 {
+        return fmt.Errorf("Batch error: more bets than announced")
+    }
+    b.remaining--
+
+    return encodeBet(b.center.writer, b.center.ID, bet)
+}
+
+// Termina el batch enviando lo que quede en el buffer
+func (b *batchWriter) Close() error {
+    if b.remaining != 0 {
+        return fmt.Errorf("Batch error: %d announced bets were not written", b.remaining)
+    }
+
+    return b.center.writer.Flush()
+}
+
+// Envia un batch de count apuestas leyendolas de reader
+func streamBatch(center *NationalLotteryCenter, reader *betReader, count int) error {
+    batch, err := center.beginBatch(count)
+    if err != nil {
+        return err
+    }
+
+    for j := 0; j < count; j++ {
+        bet, err := reader.Read()
+        if err != nil {
+            return err
+        }
+
+        err = batch.Write(bet)
+        if err != nil {
+            return err
+        }
+    }
+
+    return batch.Close()
+}
+
+// batchPlanner recorre el archivo de apuestas por delante del envio
+//  para conocer cuantas apuestas tendra el proximo batch antes de
+//  escribir su encabezado. Las apuestas leidas se descartan, por lo
+//  que nunca se mantiene un batch completo en memoria
+//
+// Un batch se cierra al alcanzar la cantidad maxima de apuestas o
+//  cuando la proxima apuesta haria superar maxBytes, lo que ocurra primero
+type batchPlanner struct {
+    reader   *betReader
+    agency   string
+    maxBytes uint
+    pending  *Bet
+    read     int
+}
+
+// Crea un planner sobre la porcion s del archivo de apuestas ubicado en path.
+// maxBytes es el tamaño maximo de un batch serializado (0 indica sin limite)
+func newBatchPlanner(path string, s shard, agency string, maxBytes uint) (*batchPlanner, error) {
+    reader, err := openBetReader(path, s)
+    if err != nil {
+        return nil, err
+    }
+
+    return &batchPlanner{reader: reader, agency: agency, maxBytes: maxBytes}, nil
+}
+
+// Devuelve la proxima apuesta sin consumirla
+func (bp *batchPlanner) peek() (Bet, error) {
+    if bp.pending == nil {
+        bet, err := bp.reader.Read()
+        if err != nil {
+            return Bet{}, err
+        }
+        bp.read++
+        bp.pending = &bet
+    }
+    return *bp.pending, nil
+}
+
+// Descarta las primeras n apuestas, ya enviadas antes de una interrupcion
+func (bp *batchPlanner) skip(n int) error {
+    bp.read += n
+    return skipBets(bp.reader, n)
+}
+
+// Devuelve la cantidad de apuestas del proximo batch y su tamaño
+//  serializado en bytes. maxCount es la cantidad maxima de apuestas
+//  (0 indica sin limite). Si no quedan apuestas devuelve io.EOF
+//
+// Si una apuesta por si sola no entra en maxBytes se devuelve un error
+func (bp *batchPlanner) next(maxCount uint) (int, int, error) {
+    count := 0
+    size := TL_LENGTH
+    for maxCount == 0 || uint(count) < maxCount {
+        bet, err := bp.peek()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return 0, 0, err
+        }
+
+        betSize := encodedBetSize(bp.agency, bet)
+        if bp.maxBytes > 0 && uint(TL_LENGTH+betSize) > bp.maxBytes {
+            return 0, 0, fmt.Errorf(
+                "Batch error: bet no. %d (document %v) needs %d bytes, batch_max_bytes is %d",
+                bp.read, bet.Redacted().Document, TL_LENGTH+betSize, bp.maxBytes,
+            )
+        }
+        if bp.maxBytes > 0 && uint(size+betSize) > bp.maxBytes {
+            break
+        }
+
+        bp.pending = nil
+        size += betSize
+        count++
+    }
+
+    if count == 0 {
+        return 0, 0, io.EOF
+    }
+    return count, size, nil
+}
+
+// Cierra el archivo del planner
+func (bp *batchPlanner) Close() {
+    bp.reader.Close()
+}
@@ -1,5 +1,12 @@
 package common
 
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
 type Bet struct {
 	Name          string
 	Surname       string
@@ -25,4 +32,34 @@ func fromRecord(record []string) Bet {
 		BirthDate: record[3],
 		Number: record[4],
 	}
-}
\ No newline at end of file
+}
+
+// Verifica que la apuesta pueda ser aceptada por el servidor:
+// * Nombre, apellido y documento no vacios
+// * Documento numerico
+// * Fecha de nacimiento con formato YYYY-MM-DD
+// * Numero entero
+// Devuelve el primer problema encontrado, o nil si la apuesta es valida.
+// Como el error suele loguearse, los datos personales que incluye van
+//  enmascarados
+func (b Bet) Validate() error {
+	if b.Name == "" {
+		return errors.New("empty name")
+	}
+	if b.Surname == "" {
+		return errors.New("empty surname")
+	}
+	if b.Document == "" {
+		return errors.New("empty document")
+	}
+	if _, err := strconv.ParseUint(b.Document, 10, 64); err != nil {
+		return fmt.Errorf("invalid document %q", maskDocument(b.Document))
+	}
+	if _, err := time.Parse("2006-01-02", b.BirthDate); err != nil {
+		return fmt.Errorf("invalid birthdate %q", maskBirthDate(b.BirthDate))
+	}
+	if _, err := strconv.Atoi(b.Number); err != nil {
+		return fmt.Errorf("invalid number %q", b.Number)
+	}
+	return nil
+}
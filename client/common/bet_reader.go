@@ -0,0 +1,156 @@
+package common
+
+import (
+    "bufio"
+    "encoding/csv"
+    "io"
+    "os"
+)
+
+// shard es una porcion del archivo de apuestas: el rango de bytes
+//  [Start, End), que siempre comienza y termina en un limite de linea
+type shard struct {
+    Start int64
+    End   int64
+}
+
+// Divide el archivo de apuestas en hasta k porciones de tamaño similar.
+// Cada limite se corre hasta el siguiente salto de linea para que
+//  ninguna apuesta quede partida entre dos porciones.
+// Las porciones vacias se descartan, por lo que pueden devolverse menos de k
+func splitShards(path string, k uint) ([]shard, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    info, err := file.Stat()
+    if err != nil {
+        return nil, err
+    }
+    size := info.Size()
+
+    if k == 0 {
+        k = 1
+    }
+
+    shards := []shard{}
+    start := int64(0)
+    for i := uint(1); i <= k; i++ {
+        end := size
+        if i < k {
+            end, err = nextLineStart(file, size*int64(i)/int64(k))
+            if err != nil {
+                return nil, err
+            }
+        }
+
+        if end > start {
+            shards = append(shards, shard{Start: start, End: end})
+            start = end
+        }
+    }
+
+    if len(shards) == 0 {
+        shards = append(shards, shard{Start: 0, End: 0})
+    }
+    return shards, nil
+}
+
+// Devuelve la posicion donde comienza la primera linea que empieza
+//  en offset o despues
+func nextLineStart(file *os.File, offset int64) (int64, error) {
+    if offset == 0 {
+        return 0, nil
+    }
+
+    // Si el byte anterior es un salto de linea, offset ya es un inicio de linea
+    reader := bufio.NewReader(io.NewSectionReader(file, offset-1, 1<<62))
+    position := offset - 1
+    for {
+        b, err := reader.ReadByte()
+        if err == io.EOF {
+            return position, nil
+        }
+        if err != nil {
+            return 0, err
+        }
+        position++
+        if b == '\n' {
+            return position, nil
+        }
+    }
+}
+
+// Devuelve la posicion donde termina la ultima linea completa del
+//  archivo ubicado en path, es decir, la siguiente al ultimo salto de
+//  linea. Una linea que todavia se esta escribiendo queda afuera
+func lastLineEnd(path string) (int64, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return 0, err
+    }
+    defer file.Close()
+
+    info, err := file.Stat()
+    if err != nil {
+        return 0, err
+    }
+
+    // Se recorre el archivo hacia atras de a bloques
+    buffer := make([]byte, 4096)
+    end := info.Size()
+    for end > 0 {
+        start := end - int64(len(buffer))
+        if start < 0 {
+            start = 0
+        }
+        block := buffer[:end-start]
+        if _, err := file.ReadAt(block, start); err != nil {
+            return 0, err
+        }
+        for i := len(block) - 1; i >= 0; i-- {
+            if block[i] == '\n' {
+                return start + int64(i) + 1, nil
+            }
+        }
+        end = start
+    }
+    return 0, nil
+}
+
+// betReader lee las apuestas de un archivo csv de a una
+type betReader struct {
+    file   *os.File
+    reader *csv.Reader
+}
+
+// Abre la porcion s del archivo de apuestas ubicado en path
+func openBetReader(path string, s shard) (*betReader, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+
+    reader := csv.NewReader(io.NewSectionReader(file, s.Start, s.End-s.Start))
+    reader.Comma = ','
+    reader.FieldsPerRecord = 5
+    reader.ReuseRecord = true
+
+    return &betReader{file: file, reader: reader}, nil
+}
+
+// Lee la proxima apuesta del archivo. Al llegar al final devuelve io.EOF
+func (r *betReader) Read() (Bet, error) {
+    record, err := r.reader.Read()
+    if err != nil {
+        return Bet{}, err
+    }
+    return fromRecord(record), nil
+}
+
+// Cierra el archivo
+func (r *betReader) Close() {
+    r.file.Close()
+}
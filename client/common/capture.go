@@ -0,0 +1,131 @@
+package common
+
+import (
+    "bufio"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "sync"
+    "time"
+)
+
+// Formato de los archivos de captura:
+//
+//  CAPTURE_MAGIC | registro | registro | ...
+//
+// Cada registro guarda un frame completo tal cual viajo por la red:
+//
+//  direccion:1byte | timestamp (unix nanos):8bytes | largo:4bytes | frame
+const CAPTURE_MAGIC = "TP0CAP01"
+
+// Direcciones de un frame capturado
+const CLIENT_TO_SERVER = 'C'
+const SERVER_TO_CLIENT = 'S'
+
+// CaptureRecord es un frame capturado junto al momento en que se observo
+type CaptureRecord struct {
+    Direction byte
+    Time      time.Time
+    Data      []byte
+}
+
+// CaptureWriter escribe registros de captura. Puede ser usado
+//  concurrentemente por los lectores de ambas direcciones de una conexion
+type CaptureWriter struct {
+    mu sync.Mutex
+    w  *bufio.Writer
+}
+
+// Crea un CaptureWriter y escribe el encabezado del formato en w
+func NewCaptureWriter(w io.Writer) (*CaptureWriter, error) {
+    writer := &CaptureWriter{w: bufio.NewWriter(w)}
+    if _, err := writer.w.WriteString(CAPTURE_MAGIC); err != nil {
+        return nil, err
+    }
+    return writer, writer.w.Flush()
+}
+
+// Escribe un registro y lo vuelca al writer subyacente
+func (c *CaptureWriter) Write(record CaptureRecord) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    header := make([]byte, 13)
+    header[0] = record.Direction
+    binary.BigEndian.PutUint64(header[1:9], uint64(record.Time.UnixNano()))
+    binary.BigEndian.PutUint32(header[9:13], uint32(len(record.Data)))
+
+    if _, err := c.w.Write(header); err != nil {
+        return err
+    }
+    if _, err := c.w.Write(record.Data); err != nil {
+        return err
+    }
+    return c.w.Flush()
+}
+
+// CaptureReader lee los registros de un archivo de captura
+type CaptureReader struct {
+    r io.Reader
+}
+
+// Crea un CaptureReader verificando el encabezado del formato
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+    magic := make([]byte, len(CAPTURE_MAGIC))
+    if _, err := io.ReadFull(r, magic); err != nil {
+        return nil, fmt.Errorf("Capture error: cannot read header: %v", err)
+    }
+    if string(magic) != CAPTURE_MAGIC {
+        return nil, errors.New("Capture error: not a capture file")
+    }
+    return &CaptureReader{r: bufio.NewReader(r)}, nil
+}
+
+// Devuelve el proximo registro. Al final de la captura devuelve io.EOF
+func (c *CaptureReader) Next() (CaptureRecord, error) {
+    header := make([]byte, 13)
+    n, err := io.ReadFull(c.r, header)
+    if n == 0 && err == io.EOF {
+        return CaptureRecord{}, io.EOF
+    }
+    if err != nil {
+        return CaptureRecord{}, errors.New("Capture error: truncated record header")
+    }
+
+    direction := header[0]
+    if direction != CLIENT_TO_SERVER && direction != SERVER_TO_CLIENT {
+        return CaptureRecord{}, fmt.Errorf("Capture error: unknown direction 0x%02x", direction)
+    }
+
+    data := make([]byte, binary.BigEndian.Uint32(header[9:13]))
+    if _, err := io.ReadFull(c.r, data); err != nil {
+        return CaptureRecord{}, errors.New("Capture error: truncated record data")
+    }
+
+    return CaptureRecord{
+        Direction: direction,
+        Time:      time.Unix(0, int64(binary.BigEndian.Uint64(header[1:9]))),
+        Data:      data,
+    }, nil
+}
+
+// Lee todos los registros de una captura
+func ReadCapture(r io.Reader) ([]CaptureRecord, error) {
+    reader, err := NewCaptureReader(r)
+    if err != nil {
+        return nil, err
+    }
+
+    records := []CaptureRecord{}
+    for {
+        record, err := reader.Next()
+        if err == io.EOF {
+            return records, nil
+        }
+        if err != nil {
+            return records, err
+        }
+        records = append(records, record)
+    }
+}
@@ -1,9 +1,11 @@
 package common
 
 import (
+    "context"
+    "fmt"
     "io"
     "os"
-    "encoding/csv"
+    "sync"
     "time"
 
     log "github.com/sirupsen/logrus"
@@ -12,15 +14,52 @@ import (
 // ClientConfig Configuracion usada por el cliente
 type ClientConfig struct {
     ID            string
-    ServerAddress string
+    // Direcciones de la central en orden de preferencia (ver serverPool)
+    ServerAddresses []string
+    ServerCooldown  time.Duration
+    ServerTimeouts  ServerTimeouts
     BetsFile      string
     BatchSize     uint
+    BatchMaxBytes uint
+    Adaptive      AdaptiveConfig
+    Parallel      uint
+    ProgressFile  string
+    DryRun        bool
+    DryRunOutput  string
+    WinnersOutput string
+    WinnersFormat string
+    VerifyWinners bool
+    WinningNumber int
+    PollBackoff    time.Duration
+    PollBackoffMax time.Duration
+    LoopPeriod     time.Duration
+    LoopLapse      time.Duration
+    RateLimit      RateLimitConfig
+    Spool          SpoolConfig
+    Watch          WatchConfig
 }
 
+// Tiempo que se espera a que el batch en vuelo se confirme cuando se
+//  pide terminar al cliente. Pasado este tiempo se corta la conexion
+const SHUTDOWN_GRACE = 5 * time.Second
+
+// Espera inicial entre consultas de ganadores si no se configura otra
+const DEFAULT_POLL_BACKOFF = 1 * time.Second
+
 // Client entidad que lo encapsula
 type Client struct {
-    config ClientConfig
-    center *NationalLotteryCenter
+    // mu protege los parametros de config que pueden recargarse
+    //  mientras el cliente corre (ver Reload)
+    mu       sync.Mutex
+    config   ClientConfig
+    center   *NationalLotteryCenter
+    dryRun   *dryRunConn
+    spool    *Spool
+    uploaded uploadStats
+    metrics  *Metrics
+    // limiter es compartido por todas las conexiones del cliente
+    limiter  *rateLimiter
+    servers  *serverPool
 }
 
 // NewClient inicializa un nuevo cliente, recibiendo la
@@ -28,27 +67,252 @@ type Client struct {
 func NewClient(config ClientConfig) *Client {
     client := &Client{
         config: config,
+        metrics: NewMetrics(config.ID),
+        limiter: newRateLimiter(config.RateLimit),
+        servers: newServerPool(config.ServerAddresses, config.ServerCooldown, config.ServerTimeouts),
     }
     return client
 }
 
+// Reload aplica los parametros de config que pueden cambiar mientras el
+//  cliente corre: tamaño de los batches (BatchSize, BatchMaxBytes y los
+//  limites del modo adaptativo), la espera entre consultas de ganadores
+//  y los limites de velocidad de subida.
+// Los batches en vuelo no se modifican, el cambio aplica desde el proximo.
+// El resto de los campos de config se ignora
+func (c *Client) Reload(config ClientConfig) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    c.config.BatchSize = config.BatchSize
+    c.config.BatchMaxBytes = config.BatchMaxBytes
+    c.config.Adaptive = config.Adaptive
+    c.config.PollBackoff = config.PollBackoff
+    c.config.PollBackoffMax = config.PollBackoffMax
+    c.config.RateLimit = config.RateLimit
+    c.limiter.update(config.RateLimit)
+}
+
+// Devuelve una copia de la configuracion vigente
+func (c *Client) settings() ClientConfig {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.config
+}
+
+// Devuelve una entrada de log con los campos action, result y client_id
+func (c *Client) logAction(action string, result string) *log.Entry {
+    return logAction(action, result).WithField("client_id", c.config.ID)
+}
+
+// Devuelve los contadores del cliente
+func (c *Client) Metrics() *Metrics {
+    return c.metrics
+}
+
 // Run realiza la logica del cliente
 // Primero recorre el archivo de apuestas y
 //  envia mediante chunks las apuestas al servidor
 // Luego, una vez terminado se empieza a realizar
 //  el poll al servidor para obtener los ganadores
-func (c *Client) Run () {
-    err := c.StartClientLoop()
+//
+// Si ctx se cancela el cliente deja terminar el batch en vuelo,
+//  guarda el progreso, cierra la conexion y devuelve context.Canceled
+func (c *Client) Run(ctx context.Context) error {
+    err := c.Upload(ctx)
+    if err != nil {
+        return err
+    }
+
+    _, err = c.Poll(ctx)
+    return err
+}
+
+// Upload sube las apuestas del archivo y envia el 'F' al servidor.
+// Si una ejecucion anterior fue interrumpida retoma desde el progreso
+//  guardado, y si ya habia enviado el 'F' no hace nada
+func (c *Client) Upload(ctx context.Context) error {
+    if c.config.DryRun {
+        return c.dryRunUpload(ctx)
+    }
+
+    progress, err := loadProgress(c.config.ProgressFile, c.config.BetsFile)
+    if err != nil {
+        c.logAction("load_progress", "fail").WithField("file_name", c.config.ProgressFile).WithError(err).Fatal()
+        return err
+    }
+
+    if progress.finished() {
+        c.logAction("client_loop", "skipped").WithField("reason", "bets already uploaded").Info()
+        return nil
+    }
+
+    err = c.StartClientLoop(ctx, progress)
+    if ctx.Err() != nil {
+        c.logAction("client_loop", "interrupted").WithField("progress_file", c.config.ProgressFile).Info()
+        return ctx.Err()
+    }
+    if err != nil {
+        c.logAction("client_loop", "fail").WithError(err).Fatal()
+    }
+    return err
+}
+
+// Poll consulta al servidor hasta obtener los ganadores de la agencia.
+// Una vez recibidos se exportan a WinnersOutput, si esta configurado,
+//  se verifican contra el archivo de apuestas y se borra el progreso
+//  de la subida. Si la verificacion falla se devuelve ErrWinnersMismatch
+//  y el progreso se conserva, para poder volver a consultar sin
+//  repetir la subida
+func (c *Client) Poll(ctx context.Context) ([]string, error) {
+    if c.config.DryRun {
+        c.logAction("check_winners", "skipped").WithField("reason", "dry run").Info()
+        return nil, nil
+    }
+
+    winners, err := c.CheckWinners(ctx)
+    if ctx.Err() != nil {
+        c.logAction("check_winners", "interrupted").Info()
+        return nil, ctx.Err()
+    }
+    if err != nil {
+        c.logAction("check_winners", "fail").WithError(err).Fatal()
+        return nil, err
+    }
+
+    err = c.ExportWinners(winners)
+    if err != nil {
+        c.logAction("export_winners", "fail").WithField("file_name", c.config.WinnersOutput).WithError(err).Error()
+        return winners, err
+    }
+    if c.config.WinnersOutput != "" {
+        c.logAction("export_winners", "success").WithFields(log.Fields{
+            "file_name":      c.config.WinnersOutput,
+            "cant_ganadores": len(winners),
+        }).Info()
+    }
+
+    if c.config.VerifyWinners {
+        err = c.verifyWinners(winners)
+        if err != nil {
+            return winners, err
+        }
+    }
+
+    progress := &progressStore{path: c.config.ProgressFile}
+    err = progress.remove()
     if err != nil {
-        log.Fatalf("action: client_loop | result: fail | error: %v", err)
-        return
+        c.logAction("remove_progress", "fail").WithField("file_name", c.config.ProgressFile).WithError(err).Error()
     }
+    return winners, nil
+}
 
-    err = c.CheckWinners()
+// Contrasta los ganadores recibidos con el archivo de apuestas y
+//  loguea el reporte. Cada diferencia se loguea por separado, hasta
+//  MAX_REPORTED_PROBLEMS por tipo
+func (c *Client) verifyWinners(winners []string) error {
+    report, err := c.AuditWinners(winners)
     if err != nil {
-        log.Fatalf("action: check_winners | result: fail | error: %v", err)
-        return
+        c.logAction("verify_winners", "fail").WithField("file_name", c.config.BetsFile).WithError(err).Error()
+        return err
+    }
+
+    anomalies := []struct {
+        kind      string
+        documents []string
+    }{
+        {"unknown_document", report.Unknown},
+        {"missing_winner", report.Missing},
+        {"unexpected_winner", report.Unexpected},
+        {"duplicate_winner", report.Duplicates},
     }
+    for _, anomaly := range anomalies {
+        for i, document := range anomaly.documents {
+            if i == MAX_REPORTED_PROBLEMS {
+                c.logAction("verify_winners", "in_progress").WithFields(log.Fields{
+                    "anomaly": anomaly.kind,
+                    "more":    len(anomaly.documents) - i,
+                }).Warn()
+                break
+            }
+            c.logAction("verify_winners", "in_progress").WithFields(log.Fields{
+                "anomaly": anomaly.kind,
+                "dni":     document,
+            }).Warn()
+        }
+    }
+
+    result, level := "success", log.InfoLevel
+    if !report.Ok() {
+        result, level = "fail", log.ErrorLevel
+    }
+    c.logAction("verify_winners", result).WithFields(log.Fields{
+        "received":   report.Received,
+        "expected":   report.Expected,
+        "unknown":    len(report.Unknown),
+        "missing":    len(report.Missing),
+        "unexpected": len(report.Unexpected),
+        "duplicates": len(report.Duplicates),
+    }).Log(level)
+
+    if !report.Ok() {
+        return ErrWinnersMismatch
+    }
+    return nil
+}
+
+// Simula la subida de las apuestas: los frames que se enviarian al
+//  servidor se escriben en DryRunOutput ("-" es stdout) y las
+//  confirmaciones se simulan. No se guarda progreso y se usa una
+//  unica conexion, para que los frames queden en orden en la salida
+func (c *Client) dryRunUpload(ctx context.Context) error {
+    output := os.Stdout
+    if c.config.DryRunOutput != "" && c.config.DryRunOutput != "-" {
+        file, err := os.Create(c.config.DryRunOutput)
+        if err != nil {
+            c.logAction("dry_run", "fail").WithField("output", c.config.DryRunOutput).WithError(err).Fatal()
+            return err
+        }
+        defer file.Close()
+        output = file
+    }
+
+    if c.config.Parallel > 1 {
+        c.logAction("dry_run", "in_progress").WithField("info", "parallel upload disabled").Warn()
+        c.config.Parallel = 1
+    }
+    c.dryRun = newDryRunConn(output)
+
+    progress, _ := loadProgress("", c.config.BetsFile)
+    err := c.StartClientLoop(ctx, progress)
+    if ctx.Err() != nil {
+        c.logAction("client_loop", "interrupted").Info()
+        return ctx.Err()
+    }
+    if err != nil {
+        c.logAction("client_loop", "fail").WithError(err).Fatal()
+        return err
+    }
+
+    written, acks := c.dryRun.stats()
+    c.logAction("dry_run", "success").WithFields(log.Fields{
+        "output":   c.config.DryRunOutput,
+        "batches":  c.uploaded.batches,
+        "acks":     acks,
+        "apuestas": c.uploaded.bets,
+        "bytes":    written,
+    }).Info()
+    return nil
+}
+
+// Abre una conexion con la central, a la primera direccion disponible,
+//  o una simulada en el modo dry-run
+func (c *Client) dial() (*NationalLotteryCenter, error) {
+    if c.dryRun != nil {
+        return newNationalLotteryCenter(c.config.ID, c.dryRun), nil
+    }
+
+    return c.servers.dial(c.config.ID, c.metrics)
 }
 
 // CheckWinners es la funcion que hace loop realizando
@@ -59,39 +323,86 @@ func (c *Client) Run () {
 //  * Aun no se encuentra hecho el sorteo, en dicho caso
 //      se frena la ejecución durante un tiempo determinado por
 //      un exponential backoff segun cuantas veces se haya
-//      rechazado la solicitud desde el servidor
+//      rechazado la solicitud desde el servidor (ver backoff)
 //  * Ya se encuentra hecho el sorteo, en dicho caso
 //      se reciben los documentos de los ganadores del sorteo. 
-func (c *Client) CheckWinners() error {
-    log.Infof("action: consulta_ganadores | result: starting")
+// Devuelve los documentos de los ganadores.
+// Si ctx se cancela durante la espera se devuelve ctx.Err()
+func (c *Client) CheckWinners(ctx context.Context) ([]string, error) {
+    c.logAction("consulta_ganadores", "starting").Info()
 
-    waitingTime := 1
+    attempts := 0
+    failures := 0
     for {
-        c.center = nil
-        c.center = NewNationalLotteryCenter(c.config.ID, c.config.ServerAddress)
+        var err error
+        c.center, err = c.dial()
+        if err != nil {
+            c.logAction("connect", "fail").WithError(err).Fatal()
+            return nil, err
+        }
 
-        log.Infof("action: polling | result: in_progress")
+        c.logAction("polling", "in_progress").Info()
+        c.metrics.Poll()
         status, winners, err := c.center.PollWinners()
 
+        // Si la conexion falla se consulta a la siguiente direccion
+        if err != nil && c.canFailover(c.center, err) && failures < c.servers.size()-1 {
+            c.logAction("polling", "fail").WithField("address", c.center.Address).WithError(err).Warn()
+            c.servers.failed(c.center.Address, err)
+            c.center.Close()
+            failures++
+            continue
+        }
+        failures = 0
+
         if err != nil {
-            log.Fatalf("action: polling | result: fail | error: %v", err)
+            c.logAction("polling", "fail").WithError(err).Fatal()
             c.center.Close()
-            return err
+            return nil, err
         }
-        log.Infof("action: polling | result: success")
+        c.logAction("polling", "success").Info()
 
         if status == WAIT {
             c.center.Close()
-            log.Infof("action: consulta_ganadores | result: in_progress | sleeping time: %v", waitingTime)
-            time.Sleep(time.Duration(waitingTime) * time.Second)
-            waitingTime *= 2
+            waitingTime := c.backoff(attempts)
+            c.logAction("consulta_ganadores", "in_progress").WithField("sleeping_time", waitingTime).Info()
+            c.metrics.Backoff(waitingTime)
+            select {
+            case <-ctx.Done():
+                return nil, ctx.Err()
+            case <-time.After(waitingTime):
+            }
+            attempts++
         } else {
-            log.Infof("action: consulta_ganadores | result: success | cant_ganadores: %v", len(winners))
+            c.logAction("consulta_ganadores", "success").WithField("cant_ganadores", len(winners)).Info()
+            c.metrics.Backoff(0)
             c.center.Close()
+            return winners, nil
+        }
+    }
+}
+
+// Devuelve la espera luego de attempts consultas rechazadas: PollBackoff
+//  duplicado por cada rechazo anterior, sin superar PollBackoffMax
+//  (si esta configurado). Se calcula en cada consulta para tomar los
+//  parametros recargados
+func (c *Client) backoff(attempts int) time.Duration {
+    settings := c.settings()
+
+    wait := settings.PollBackoff
+    if wait <= 0 {
+        wait = DEFAULT_POLL_BACKOFF
+    }
+    for i := 0; i < attempts; i++ {
+        if settings.PollBackoffMax > 0 && wait >= settings.PollBackoffMax {
             break
         }
+        wait *= 2
     }
-    return nil
+    if settings.PollBackoffMax > 0 && wait > settings.PollBackoffMax {
+        wait = settings.PollBackoffMax
+    }
+    return wait
 }
 
 // StartClientLoop es la funcion que lee el archivo y envia
@@ -99,79 +410,298 @@ func (c *Client) CheckWinners() error {
 // Se genera una conexion con el servidor y una vez establecida
 //  se comienza a leer el archivo csv completando los llamados chunks
 //  que no son mas que tiras de apuestas que se envian en conjunto
-func (c *Client) StartClientLoop() error {
-    c.center = NewNationalLotteryCenter(c.config.ID, c.config.ServerAddress)
-
-    defer c.center.Close()
+//
+// Si se configura Parallel mayor a 1, el archivo se divide en esa
+//  cantidad de porciones que se envian en paralelo, cada una por su
+//  propia conexion. El servidor cuenta las agencias por los 'F' recibidos,
+//  por lo que el 'F' se envia una unica vez, recien cuando todas las
+//  porciones fueron confirmadas
+//
+// Cada batch confirmado se registra en progress. Si la subida se
+//  retoma luego de una interrupcion, se usan las mismas porciones y
+//  se saltean las apuestas ya confirmadas
+func (c *Client) StartClientLoop(ctx context.Context, progress *progressStore) error {
+    if c.config.Spool.Dir != "" && c.dryRun == nil {
+        return c.storeAndForward(ctx, progress)
+    }
 
-    file, err := os.Open(c.config.BetsFile)
+    var err error
+    c.center, err = c.dial()
     if err != nil {
-        log.Fatalf("action: abrir_archivo | result: fail | file_name: %v | error: %v", c.config.BetsFile, err)
+        c.logAction("connect", "fail").WithError(err).Fatal()
         return err
     }
 
-    defer file.Close()
+    // La subida puede reemplazar c.center si la central falla
+    defer func() { c.center.Close() }()
 
-    reader := csv.NewReader(file)
-    reader.Comma = ','
-    reader.FieldsPerRecord = 5
+    err = c.uploadBets(ctx, progress)
+    if err != nil {
+        return err
+    }
 
-    batch := make([]Bet, 0)
-    i := 0
-    for {
-        // Read one record from csv
-        record, err := reader.Read()
-        if err == io.EOF {
-            err = c.center.sendBatch(batch)
-            if err != nil {
-                return err
-            }
+    err = c.center.Finish()
+    if err != nil {
+        c.logAction("finishing_connection", "fail").WithError(err).Fatal()
+        return err
+    }
 
-            err = c.center.waitConfirmation()
-            if err != nil {
-                log.Fatalf("action: wait_confirmation | result: fail | error: %v", err)
-                return err
-            }
+    err = progress.finish()
+    if err != nil {
+        c.logAction("save_progress", "fail").WithField("file_name", c.config.ProgressFile).WithError(err).Error()
+    }
+    return nil
+}
 
-            i++
-            log.Infof("action: batch enviado | result: success | no: %v | info: ultimo", i)
+// Sube todas las apuestas del archivo por c.center, sin enviar el 'F'.
+// Si LoopLapse esta configurado el archivo se sube de forma periodica
+//  (ver uploadPeriodically)
+func (c *Client) uploadBets(ctx context.Context, progress *progressStore) error {
+    start := time.Now()
+    var stats uploadStats
+    var err error
+    if c.config.LoopLapse > 0 {
+        stats, err = c.uploadPeriodically(ctx, progress)
+    } else {
+        stats, err = c.uploadOnce(ctx, progress)
+    }
+    if err != nil {
+        return err
+    }
 
-            batch = nil
-            break
+    c.uploaded = stats
+    elapsed := time.Since(start)
+    c.logAction("upload", "success").WithFields(log.Fields{
+        "shards":   len(progress.shards()),
+        "batches":  stats.batches,
+        "apuestas": stats.bets,
+        "bytes":    stats.bytes,
+        "elapsed":  elapsed.String(),
+        "rate": fmt.Sprintf("%.1f apuestas/s %.1f KiB/s",
+            float64(stats.bets)/elapsed.Seconds(),
+            float64(stats.bytes)/1024/elapsed.Seconds()),
+    }).Info()
+    return nil
+}
+
+// Sube el archivo completo dividido en Parallel porciones.
+// Si progress tiene porciones guardadas se retoma la subida con ellas
+func (c *Client) uploadOnce(ctx context.Context, progress *progressStore) (uploadStats, error) {
+    var err error
+    shards := progress.shards()
+    if shards == nil {
+        shards, err = splitShards(c.config.BetsFile, c.config.Parallel)
+        if err != nil {
+            c.logAction("abrir_archivo", "fail").WithField("file_name", c.config.BetsFile).WithError(err).Error()
+            return uploadStats{}, err
         }
 
+        err = progress.start(shards)
         if err != nil {
-            log.Fatalf("action: read_record | result: fail | error: %v", err)
-            // closes in defer
-            return err
+            c.logAction("save_progress", "fail").WithField("file_name", c.config.ProgressFile).WithError(err).Error()
+            return uploadStats{}, err
         }
+    } else {
+        c.logAction("resume_upload", "in_progress").WithField("shards", len(shards)).Info()
+    }
 
-        batch = append(batch, fromRecord(record))
+    return c.uploadShards(ctx, shards, progress)
+}
 
-        if uint(len(batch)) == c.config.BatchSize {
-            c.center.sendBatch(batch)
-            if err != nil {
-                return err
-            }
+// Totales de una subida de apuestas
+type uploadStats struct {
+    batches int
+    bets    int
+    bytes   int
+}
 
-            c.center.waitConfirmation()
-            if err != nil {
-                log.Fatalf("action: wait_confirmation | result: fail | error: %v", err)
-                return err
+// Suma a s los totales de other
+func (s *uploadStats) add(other uploadStats) {
+    s.batches += other.batches
+    s.bets += other.bets
+    s.bytes += other.bytes
+}
+
+// Sube todas las porciones del archivo en paralelo y espera a que
+//  terminen. La primera porcion usa la conexion principal del cliente,
+//  las demas abren una conexion propia
+//
+// Devuelve la suma de lo enviado por todas las porciones, o el primer
+//  error ocurrido
+func (c *Client) uploadShards(ctx context.Context, shards []shard, progress *progressStore) (uploadStats, error) {
+    results := make([]uploadStats, len(shards))
+    errs := make([]error, len(shards))
+
+    // La porcion 0 usa la conexion principal, que puede reemplazarse si
+    //  la central falla: la que quede en uso se asigna a c.center cuando
+    //  terminan todas las porciones
+    first := c.center
+    replaced := first
+
+    var wg sync.WaitGroup
+    for i, s := range shards {
+        wg.Add(1)
+        go func(i int, s shard) {
+            defer wg.Done()
+
+            center := first
+            if i > 0 && c.spool == nil {
+                var err error
+                center, err = c.dial()
+                if err != nil {
+                    c.logAction("connect", "fail").WithField("shard", i).WithError(err).Error()
+                    errs[i] = err
+                    return
+                }
+                defer func() { center.Close() }()
             }
 
-            i++
-            log.Infof("action: batch enviado | result: success | no: %v", i)
+            results[i], center, errs[i] = c.uploadShardFailover(ctx, center, s, i, progress)
+            if i == 0 {
+                replaced = center
+            }
+        }(i, s)
+    }
+    wg.Wait()
+    c.center = replaced
 
-            batch = nil
-            continue
+    total := uploadStats{}
+    for i := range shards {
+        if errs[i] != nil {
+            return total, errs[i]
         }
+        total.batches += results[i].batches
+        total.bets += results[i].bets
+        total.bytes += results[i].bytes
     }
+    return total, nil
+}
 
-    err = c.center.Finish()
+// Envia en chunks las apuestas de una porcion del archivo por center
+//
+// Cada chunk se envia a medida que se lee: un planner recorre el
+//  archivo por delante para conocer la cantidad de apuestas del chunk
+//  y luego cada apuesta se serializa directo del archivo a la conexion.
+// Un chunk se cierra al llegar a BatchSize apuestas o cuando la
+//  siguiente apuesta haria superar BatchMaxBytes bytes. En el modo
+//  adaptativo la cantidad de apuestas se ajusta segun cuanto tarda
+//  el servidor en confirmar cada chunk
+func (c *Client) uploadShard(ctx context.Context, center *NationalLotteryCenter, s shard, no int, progress *progressStore) (uploadStats, error) {
+    if c.spool != nil {
+        return c.spoolShard(ctx, s, no, progress)
+    }
+    stats := uploadStats{}
+
+    settings := c.settings()
+    planner, err := newBatchPlanner(c.config.BetsFile, s, c.config.ID, settings.BatchMaxBytes)
     if err != nil {
-        log.Fatalf("action: finishing_connection | result: fail | error: %v", err)
+        c.logAction("abrir_archivo", "fail").WithField("file_name", c.config.BetsFile).WithError(err).Error()
+        return stats, err
     }
 
-    return err
-}
\ No newline at end of file
+    defer planner.Close()
+
+    reader, err := openBetReader(c.config.BetsFile, s)
+    if err != nil {
+        c.logAction("abrir_archivo", "fail").WithField("file_name", c.config.BetsFile).WithError(err).Error()
+        return stats, err
+    }
+
+    defer reader.Close()
+
+    // Se saltean las apuestas confirmadas antes de una interrupcion
+    confirmed := progress.confirmed(no)
+    if err := planner.skip(confirmed); err != nil {
+        c.logAction("resume_upload", "fail").WithField("shard", no).WithError(err).Error()
+        return stats, err
+    }
+    if err := skipBets(reader, confirmed); err != nil {
+        c.logAction("resume_upload", "fail").WithField("shard", no).WithError(err).Error()
+        return stats, err
+    }
+    c.metrics.BetsRead(confirmed)
+
+    stop := abortOnShutdown(ctx, center)
+    defer stop()
+
+    sizer := newBatchSizer(settings.BatchSize, settings.Adaptive)
+
+    for {
+        if ctx.Err() != nil {
+            return stats, ctx.Err()
+        }
+
+        // Se toman los limites recargados, si cambiaron
+        if current := c.settings(); current.BatchSize != settings.BatchSize ||
+            current.BatchMaxBytes != settings.BatchMaxBytes || current.Adaptive != settings.Adaptive {
+            settings = current
+            planner.maxBytes = settings.BatchMaxBytes
+            sizer.update(settings.BatchSize, settings.Adaptive)
+        }
+
+        count, size, err := planner.next(sizer.Size())
+        if err == io.EOF {
+            return stats, nil
+        }
+
+        if err != nil {
+            c.logAction("read_record", "fail").WithField("shard", no).WithError(err).Error()
+            return stats, err
+        }
+
+        waited, err := c.limiter.wait(ctx, count, size)
+        if err != nil {
+            return stats, err
+        }
+        c.metrics.RateLimited(waited)
+
+        start := time.Now()
+        err = streamBatch(center, reader, count)
+        if err != nil {
+            c.logAction("send_batch", "fail").WithFields(log.Fields{"shard": no, "batch_no": stats.batches + 1}).WithError(err).Error()
+            return stats, err
+        }
+        c.metrics.BetsRead(count)
+        c.metrics.BatchSent(count, size)
+
+        err = center.waitConfirmation()
+        if err != nil {
+            c.logAction("wait_confirmation", "fail").WithFields(log.Fields{"shard": no, "batch_no": stats.batches + 1}).WithError(err).Error()
+            return stats, err
+        }
+        latency := time.Since(start)
+        c.metrics.BatchConfirmed(latency)
+        sizer.Observe(latency)
+
+        err = progress.confirm(no, count)
+        if err != nil {
+            c.logAction("save_progress", "fail").WithField("file_name", c.config.ProgressFile).WithError(err).Error()
+            return stats, err
+        }
+
+        stats.batches++
+        stats.bets += count
+        stats.bytes += size
+        c.logAction("batch enviado", "success").WithFields(log.Fields{
+            "shard":    no,
+            "batch_no": stats.batches,
+            "apuestas": count,
+            "bytes":    size,
+        }).Info()
+    }
+}
+
+// Cuando ctx se cancela le da SHUTDOWN_GRACE al batch en vuelo para
+//  confirmarse y luego corta la conexion, destrabando cualquier
+//  lectura o escritura pendiente. Devuelve la funcion que deja de vigilar
+func abortOnShutdown(ctx context.Context, center *NationalLotteryCenter) func() {
+    done := make(chan struct{})
+    go func() {
+        select {
+        case <-ctx.Done():
+            center.SetDeadline(time.Now().Add(SHUTDOWN_GRACE))
+        case <-done:
+        }
+    }()
+    return func() { close(done) }
+}
@@ -0,0 +1,282 @@
+package common
+
+import (
+    "encoding/binary"
+    "fmt"
+    "io"
+)
+
+// Largo maximo aceptado para un campo. Evita reservar memoria de mas
+//  cuando el campo L viene corrupto
+const MAX_FIELD_LENGTH = 1 << 20
+
+// Frame es un elemento del protocolo TLV ya decodificado
+//
+// Los frames compuestos (Z, B, W) contienen a sus elementos en Children,
+//  los campos simples guardan su contenido en Value.
+// Raw contiene los bytes del frame tal cual viajaron por la red.
+type Frame struct {
+    Offset   int64
+    Tag      byte
+    Length   int64
+    Value    []byte
+    Children []*Frame
+    Raw      []byte
+}
+
+// DecodeError indica un frame mal formado o truncado,
+//  junto al offset (en bytes) donde se detecto el problema
+type DecodeError struct {
+    Offset int64
+    Msg    string
+}
+
+func (e *DecodeError) Error() string {
+    return fmt.Sprintf("offset %d: %s", e.Offset, e.Msg)
+}
+
+// Devuelve el nombre legible de un tag del protocolo
+func TagName(tag byte) string {
+    switch tag {
+    case BATCH_TYPE:
+        return "batch"
+    case BET_TYPE:
+        return "bet"
+    case AGENCY_NAME_TYPE:
+        return "agency"
+    case NAME_TYPE:
+        return "name"
+    case LAST_NAME_TYPE:
+        return "last_name"
+    case DOCUMENT_TYPE:
+        return "document"
+    case BIRTHDATE_TYPE:
+        return "birthdate"
+    case NUMBER_TYPE:
+        return "number"
+    case POLL_TYPE:
+        return "poll"
+    case FINISH_TYPE:
+        return "finish"
+    case WINNERS_TYPE:
+        return "winners"
+    case AWAIT_TYPE:
+        return "await"
+    case OK_TYPE:
+        return "ok"
+    default:
+        return "unknown"
+    }
+}
+
+// Indica si el tag corresponde a un campo de una apuesta
+func isFieldType(tag byte) bool {
+    switch tag {
+    case AGENCY_NAME_TYPE, NAME_TYPE, LAST_NAME_TYPE, DOCUMENT_TYPE, BIRTHDATE_TYPE, NUMBER_TYPE:
+        return true
+    }
+    return false
+}
+
+// FrameDecoder lee frames del protocolo TLV de un stream de bytes,
+//  sin importar si fueron enviados por el cliente o por el servidor
+type FrameDecoder struct {
+    r      io.Reader
+    offset int64
+    raw    []byte
+}
+
+// Crea un decoder que lee de r
+func NewFrameDecoder(r io.Reader) *FrameDecoder {
+    return &FrameDecoder{r: r}
+}
+
+// Devuelve la cantidad de bytes consumidos hasta el momento
+func (d *FrameDecoder) Offset() int64 {
+    return d.offset
+}
+
+// Lee exactamente n bytes, acumulandolos en el frame actual
+func (d *FrameDecoder) read(n int) ([]byte, error) {
+    start := d.offset
+    data := make([]byte, n)
+    read, err := io.ReadFull(d.r, data)
+    d.offset += int64(read)
+    d.raw = append(d.raw, data[:read]...)
+    if err != nil {
+        if err == io.EOF || err == io.ErrUnexpectedEOF {
+            return data[:read], &DecodeError{
+                Offset: start,
+                Msg:    fmt.Sprintf("truncated frame: expected %d bytes, got %d", n, read),
+            }
+        }
+        return data[:read], err
+    }
+    return data, nil
+}
+
+// Lee un entero de 4 bytes big endian
+func (d *FrameDecoder) readLength() (int64, error) {
+    data, err := d.read(4)
+    if err != nil {
+        return -1, err
+    }
+    return int64(binary.BigEndian.Uint32(data)), nil
+}
+
+// Next devuelve el proximo frame del stream
+//
+// Si el stream termina justo entre dos frames se devuelve io.EOF.
+// Si el frame esta mal formado o truncado se devuelve lo que se
+//  pudo decodificar junto a un *DecodeError. Luego de un error el
+//  decoder ya no puede resincronizarse con el stream.
+func (d *FrameDecoder) Next() (*Frame, error) {
+    d.raw = nil
+
+    tag := make([]byte, 1)
+    n, err := io.ReadFull(d.r, tag)
+    if n == 0 {
+        if err == io.ErrUnexpectedEOF {
+            err = io.EOF
+        }
+        return nil, err
+    }
+    d.offset++
+    d.raw = append(d.raw, tag[0])
+
+    frame := &Frame{Offset: d.offset - 1, Tag: tag[0], Length: -1}
+    err = d.decodeBody(frame)
+    frame.Raw = d.raw
+    return frame, err
+}
+
+// Decodifica el contenido de un frame cuyo tag ya fue leido
+func (d *FrameDecoder) decodeBody(frame *Frame) error {
+    switch frame.Tag {
+    case BATCH_TYPE:
+        return d.decodeList(frame, BET_TYPE)
+    case WINNERS_TYPE:
+        return d.decodeList(frame, DOCUMENT_TYPE)
+    case BET_TYPE:
+        return d.decodeBet(frame)
+    case POLL_TYPE:
+        value, err := d.read(4)
+        frame.Value = value
+        return err
+    case FINISH_TYPE, AWAIT_TYPE, OK_TYPE:
+        return nil
+    default:
+        if isFieldType(frame.Tag) {
+            return d.decodeField(frame)
+        }
+        return &DecodeError{
+            Offset: frame.Offset,
+            Msg:    fmt.Sprintf("unknown tag 0x%02x", frame.Tag),
+        }
+    }
+}
+
+// Decodifica un frame cuyo L es la cantidad de elementos que le siguen,
+//  todos con el mismo tag (apuestas en un batch, documentos en winners)
+func (d *FrameDecoder) decodeList(frame *Frame, childTag byte) error {
+    count, err := d.readLength()
+    frame.Length = count
+    if err != nil {
+        return err
+    }
+
+    for i := int64(0); i < count; i++ {
+        child, err := d.decodeChild()
+        if child != nil {
+            frame.Children = append(frame.Children, child)
+        }
+        if err != nil {
+            return err
+        }
+        if child.Tag != childTag {
+            return &DecodeError{
+                Offset: child.Offset,
+                Msg:    fmt.Sprintf("unexpected tag '%c' inside %s: expected '%c'", child.Tag, TagName(frame.Tag), childTag),
+            }
+        }
+    }
+    return nil
+}
+
+// Decodifica un frame anidado, guardando sus bytes crudos
+func (d *FrameDecoder) decodeChild() (*Frame, error) {
+    start := len(d.raw)
+    tag, err := d.read(1)
+    if err != nil {
+        return nil, err
+    }
+
+    child := &Frame{Offset: d.offset - 1, Tag: tag[0], Length: -1}
+    switch {
+    case child.Tag == BET_TYPE:
+        err = d.decodeBet(child)
+    case isFieldType(child.Tag):
+        err = d.decodeField(child)
+    default:
+        err = &DecodeError{
+            Offset: child.Offset,
+            Msg:    fmt.Sprintf("unknown tag 0x%02x", child.Tag),
+        }
+    }
+    child.Raw = d.raw[start:]
+    return child, err
+}
+
+// Decodifica una apuesta: su largo y luego los campos que la componen
+func (d *FrameDecoder) decodeBet(frame *Frame) error {
+    length, err := d.readLength()
+    frame.Length = length
+    if err != nil {
+        return err
+    }
+
+    end := d.offset + length
+    for d.offset < end {
+        child, err := d.decodeChild()
+        if child != nil {
+            frame.Children = append(frame.Children, child)
+        }
+        if err != nil {
+            return err
+        }
+        if !isFieldType(child.Tag) {
+            return &DecodeError{
+                Offset: child.Offset,
+                Msg:    fmt.Sprintf("unexpected tag '%c' inside bet", child.Tag),
+            }
+        }
+    }
+
+    if d.offset != end {
+        return &DecodeError{
+            Offset: frame.Offset,
+            Msg:    fmt.Sprintf("bet length mismatch: declared %d bytes, fields use %d", length, d.offset-end+length),
+        }
+    }
+    return nil
+}
+
+// Decodifica un campo simple: largo y valor
+func (d *FrameDecoder) decodeField(frame *Frame) error {
+    length, err := d.readLength()
+    frame.Length = length
+    if err != nil {
+        return err
+    }
+
+    if length > MAX_FIELD_LENGTH {
+        return &DecodeError{
+            Offset: frame.Offset + 1,
+            Msg:    fmt.Sprintf("field length %d exceeds limit of %d bytes", length, MAX_FIELD_LENGTH),
+        }
+    }
+
+    value, err := d.read(int(length))
+    frame.Value = value
+    return err
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+    "io"
+    "net"
+    "sync"
+    "time"
+)
+
+// dryRunConn reemplaza la conexion con la central en el modo dry-run
+//
+// Todo lo que se escribe se vuelca en un writer local (un archivo o
+//  stdout) y cada lectura devuelve un 'O', simulando que el servidor
+//  confirma todos los batches
+type dryRunConn struct {
+    mu      sync.Mutex
+    w       io.Writer
+    written int64
+    acks    int64
+}
+
+func newDryRunConn(w io.Writer) *dryRunConn {
+    return &dryRunConn{w: w}
+}
+
+func (c *dryRunConn) Read(b []byte) (int, error) {
+    if len(b) == 0 {
+        return 0, nil
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.acks++
+    b[0] = OK_TYPE
+    return 1, nil
+}
+
+func (c *dryRunConn) Write(b []byte) (int, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    n, err := c.w.Write(b)
+    c.written += int64(n)
+    return n, err
+}
+
+// Devuelve la cantidad de bytes escritos y de confirmaciones simuladas
+func (c *dryRunConn) stats() (int64, int64) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.written, c.acks
+}
+
+func (c *dryRunConn) Close() error                       { return nil }
+func (c *dryRunConn) LocalAddr() net.Addr                { return dryRunAddr{} }
+func (c *dryRunConn) RemoteAddr() net.Addr               { return dryRunAddr{} }
+func (c *dryRunConn) SetDeadline(t time.Time) error      { return nil }
+func (c *dryRunConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dryRunConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type dryRunAddr struct{}
+
+func (dryRunAddr) Network() string { return "dry-run" }
+func (dryRunAddr) String() string  { return "dry-run" }
@@ -0,0 +1,72 @@
+package common
+
+import (
+    "bufio"
+    "net"
+)
+
+// Largo del encabezado de un TLV: tipo (1 byte) y largo (4 bytes)
+const TL_LENGTH = 5
+
+// connWriter adapta la conexion para que cada escritura envie todos
+//  los bytes, previniendo las anomalias de short-write
+type connWriter struct {
+    conn net.Conn
+}
+
+func (c connWriter) Write(data []byte) (int, error) {
+    if err := sendData(c.conn, data); err != nil {
+        return 0, err
+    }
+    return len(data), nil
+}
+
+// Escribe el encabezado de un TLV: el tipo y el largo en big endian.
+// No reserva memoria: los bytes se copian directo al buffer del writer.
+//
+// Los errores de bufio.Writer persisten, por lo que alcanza con
+//  devolver el resultado de la ultima escritura
+func writeHeader(w *bufio.Writer, tlvType byte, length int) error {
+    w.WriteByte(tlvType)
+    w.WriteByte(byte(length >> 24))
+    w.WriteByte(byte(length >> 16))
+    w.WriteByte(byte(length >> 8))
+    return w.WriteByte(byte(length))
+}
+
+// Escribe un campo string segun el protocolo TLV propuesto
+func writeString(w *bufio.Writer, fieldType byte, field string) error {
+    writeHeader(w, fieldType, len(field))
+    _, err := w.WriteString(field)
+    return err
+}
+
+// Devuelve el largo del valor de una apuesta serializada,
+//  es decir sin contar el 'B' ni el largo
+func betLength(agency string, bet Bet) int {
+    return 6*TL_LENGTH +
+        len(agency) +
+        len(bet.Name) +
+        len(bet.Surname) +
+        len(bet.Document) +
+        len(bet.BirthDate) +
+        len(bet.Number)
+}
+
+// Devuelve el largo total de una apuesta serializada, encabezado incluido
+func encodedBetSize(agency string, bet Bet) int {
+    return TL_LENGTH + betLength(agency, bet)
+}
+
+// Escribe una apuesta completa en w utilizando el protocolo TLV propuesto.
+// Como el largo se calcula de antemano, los campos se escriben
+//  directamente sin armar slices intermedios
+func encodeBet(w *bufio.Writer, agency string, bet Bet) error {
+    writeHeader(w, BET_TYPE, betLength(agency, bet))
+    writeString(w, AGENCY_NAME_TYPE, agency)
+    writeString(w, NAME_TYPE, bet.Name)
+    writeString(w, LAST_NAME_TYPE, bet.Surname)
+    writeString(w, DOCUMENT_TYPE, bet.Document)
+    writeString(w, BIRTHDATE_TYPE, bet.BirthDate)
+    return writeString(w, NUMBER_TYPE, bet.Number)
+}
@@ -0,0 +1,185 @@
+package common
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/binary"
+    "io"
+    "testing"
+)
+
+var benchBet = Bet{
+    Name:      "Santiago Lionel",
+    Surname:   "Lorca",
+    Document:  "30904465",
+    BirthDate: "1999-03-17",
+    Number:    "2201",
+}
+
+func benchBatch(size int) []Bet {
+    batch := make([]Bet, size)
+    for i := range batch {
+        batch[i] = benchBet
+    }
+    return batch
+}
+
+// Serializacion anterior al encoder, basada en append de slices.
+// Se mantiene solo como referencia para las pruebas y los benchmarks
+func legacySerializeString(field_type byte, field string) []byte {
+    serialized := []byte{}
+    serialized = append(serialized, field_type)
+
+    length := make([]byte, 4)
+    binary.BigEndian.PutUint32(length, uint32(len(field)))
+    serialized = append(serialized, length...)
+
+    return append(serialized, []byte(field)...)
+}
+
+func legacySerializeBet(agency string, bet Bet) []byte {
+    serialized := []byte{}
+    serialized = append(serialized, legacySerializeString(AGENCY_NAME_TYPE, agency)...)
+    serialized = append(serialized, legacySerializeString(NAME_TYPE, bet.Name)...)
+    serialized = append(serialized, legacySerializeString(LAST_NAME_TYPE, bet.Surname)...)
+    serialized = append(serialized, legacySerializeString(DOCUMENT_TYPE, bet.Document)...)
+    serialized = append(serialized, legacySerializeString(BIRTHDATE_TYPE, bet.BirthDate)...)
+    serialized = append(serialized, legacySerializeString(NUMBER_TYPE, bet.Number)...)
+
+    first_part := []byte{}
+    first_part = append(first_part, BET_TYPE)
+    length := make([]byte, 4)
+    binary.BigEndian.PutUint32(length, uint32(len(serialized)))
+    first_part = append(first_part, length...)
+
+    return append(first_part, serialized...)
+}
+
+func legacySerializeBatch(agency string, batch []Bet) []byte {
+    first_part := []byte{}
+    first_part = append(first_part, BATCH_TYPE)
+    length := make([]byte, 4)
+    binary.BigEndian.PutUint32(length, uint32(len(batch)))
+    first_part = append(first_part, length...)
+
+    data := []byte{}
+    for _, bet := range batch {
+        data = append(data, legacySerializeBet(agency, bet)...)
+    }
+
+    return append(first_part, data...)
+}
+
+// Verifica que el encoder produzca exactamente los mismos bytes
+//  que la serializacion anterior antes de medirlo
+func checkEncoding(b *testing.B, batch []Bet) {
+    buffer := &bytes.Buffer{}
+    center := &NationalLotteryCenter{ID: "1", writer: bufio.NewWriter(buffer)}
+    if err := center.sendBatch(batch); err != nil {
+        b.Fatal(err)
+    }
+    if !bytes.Equal(buffer.Bytes(), legacySerializeBatch("1", batch)) {
+        b.Fatal("encoder output differs from the legacy serialization")
+    }
+}
+
+// Apuestas con campos vacios y nombres multibyte, cuyo largo en
+//  bytes difiere de su cantidad de caracteres
+var encodingCases = []Bet{
+    benchBet,
+    {},
+    {Name: "", Surname: "Lorca", Document: "", BirthDate: "1999-03-17", Number: ""},
+    {Name: "Ñandú José", Surname: "Muñoz Güemes", Document: "30904465", BirthDate: "1999-03-17", Number: "2201"},
+    {Name: "李小龍", Surname: "Zoë 🎲", Document: "1", BirthDate: "2000-01-01", Number: "7"},
+}
+
+func TestEncodeBet(t *testing.T) {
+    for _, agency := range []string{"1", "", "agencia 5"} {
+        for _, bet := range encodingCases {
+            buffer := &bytes.Buffer{}
+            writer := bufio.NewWriter(buffer)
+            if err := encodeBet(writer, agency, bet); err != nil {
+                t.Fatal(err)
+            }
+            writer.Flush()
+
+            want := legacySerializeBet(agency, bet)
+            if !bytes.Equal(buffer.Bytes(), want) {
+                t.Errorf("encodeBet(%q, %+v) = %v, want %v", agency, bet, buffer.Bytes(), want)
+            }
+            if size := encodedBetSize(agency, bet); size != len(want) {
+                t.Errorf("encodedBetSize(%q, %+v) = %d, want %d", agency, bet, size, len(want))
+            }
+        }
+    }
+}
+
+func TestSendBatch(t *testing.T) {
+    batches := [][]Bet{
+        {},
+        {encodingCases[1]},
+        encodingCases,
+        benchBatch(100),
+    }
+
+    for _, batch := range batches {
+        buffer := &bytes.Buffer{}
+        center := &NationalLotteryCenter{ID: "1", writer: bufio.NewWriter(buffer)}
+        if err := center.sendBatch(batch); err != nil {
+            t.Fatal(err)
+        }
+        if !bytes.Equal(buffer.Bytes(), legacySerializeBatch("1", batch)) {
+            t.Errorf("sendBatch of %d bets differs from the legacy serialization", len(batch))
+        }
+    }
+}
+
+func BenchmarkLegacySerializeBet(b *testing.B) {
+    b.ReportAllocs()
+    for i := 0; i < b.N; i++ {
+        io.Discard.Write(legacySerializeBet("1", benchBet))
+    }
+}
+
+func BenchmarkEncodeBet(b *testing.B) {
+    checkEncoding(b, benchBatch(1))
+    writer := bufio.NewWriter(io.Discard)
+
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        encodeBet(writer, "1", benchBet)
+    }
+    writer.Flush()
+}
+
+func benchmarkLegacySendBatch(b *testing.B, size int) {
+    batch := benchBatch(size)
+
+    b.ReportAllocs()
+    for i := 0; i < b.N; i++ {
+        io.Discard.Write(legacySerializeBatch("1", batch))
+    }
+}
+
+func benchmarkSendBatch(b *testing.B, size int) {
+    batch := benchBatch(size)
+    checkEncoding(b, batch)
+    center := &NationalLotteryCenter{ID: "1", writer: bufio.NewWriter(io.Discard)}
+
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        if err := center.sendBatch(batch); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
+
+func BenchmarkLegacySendBatch10(b *testing.B)   { benchmarkLegacySendBatch(b, 10) }
+func BenchmarkLegacySendBatch100(b *testing.B)  { benchmarkLegacySendBatch(b, 100) }
+func BenchmarkLegacySendBatch1000(b *testing.B) { benchmarkLegacySendBatch(b, 1000) }
+
+func BenchmarkSendBatch10(b *testing.B)   { benchmarkSendBatch(b, 10) }
+func BenchmarkSendBatch100(b *testing.B)  { benchmarkSendBatch(b, 100) }
+func BenchmarkSendBatch1000(b *testing.B) { benchmarkSendBatch(b, 1000) }
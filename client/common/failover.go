@@ -0,0 +1,210 @@
+package common
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "sort"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+)
+
+// Tiempo que una direccion de la central queda relegada luego de fallar,
+//  si no se configura otro
+const DEFAULT_SERVER_COOLDOWN = 30 * time.Second
+
+// Estado de una direccion de la central
+type endpoint struct {
+    address   string
+    failures  int
+    coolUntil time.Time
+    lastError error
+}
+
+// serverPool elige a que direccion de la central conectarse.
+//
+// Las direcciones se prueban en el orden configurado: la primera es la
+//  principal y las demas son de respaldo. Cuando una direccion falla
+//  (al conectarse o durante la conexion) queda en cool-down: mientras
+//  dure se prueba despues de las sanas. Si todas estan en cool-down se
+//  prueban igual, primero la que antes termina su cool-down, por lo que
+//  con una unica direccion el comportamiento es el de siempre
+type serverPool struct {
+    mu        sync.Mutex
+    endpoints []*endpoint
+    cooldown  time.Duration
+    timeouts  ServerTimeouts
+}
+
+// Crea el pool con las direcciones en orden de preferencia. Las
+//  conexiones se abren con las esperas maximas de timeouts
+func newServerPool(addresses []string, cooldown time.Duration, timeouts ServerTimeouts) *serverPool {
+    if cooldown <= 0 {
+        cooldown = DEFAULT_SERVER_COOLDOWN
+    }
+
+    pool := &serverPool{cooldown: cooldown, timeouts: timeouts}
+    for _, address := range addresses {
+        pool.endpoints = append(pool.endpoints, &endpoint{address: address})
+    }
+    return pool
+}
+
+// Devuelve las direcciones en el orden en que deben probarse
+func (p *serverPool) candidates() []*endpoint {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    now := time.Now()
+    healthy := []*endpoint{}
+    cooling := []*endpoint{}
+    for _, e := range p.endpoints {
+        if now.Before(e.coolUntil) {
+            cooling = append(cooling, e)
+        } else {
+            healthy = append(healthy, e)
+        }
+    }
+    sort.SliceStable(cooling, func(i, j int) bool {
+        return cooling[i].coolUntil.Before(cooling[j].coolUntil)
+    })
+    return append(healthy, cooling...)
+}
+
+// Registra una falla de la direccion y la pone en cool-down. Con una
+//  unica direccion no hay alternativa, por lo que no se registra nada
+func (p *serverPool) failed(address string, err error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    if len(p.endpoints) < 2 {
+        return
+    }
+
+    for _, e := range p.endpoints {
+        if e.address == address {
+            e.failures++
+            e.lastError = err
+            e.coolUntil = time.Now().Add(p.cooldown)
+            logAction("server_health", "cooling_down").WithFields(log.Fields{
+                "address":  address,
+                "failures": e.failures,
+                "until":    e.coolUntil.Format(time.RFC3339),
+            }).WithError(err).Warn()
+        }
+    }
+}
+
+// Registra una conexion exitosa con la direccion
+func (p *serverPool) succeeded(address string) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    for _, e := range p.endpoints {
+        if e.address == address {
+            e.failures = 0
+            e.lastError = nil
+            e.coolUntil = time.Time{}
+        }
+    }
+}
+
+// Cantidad de direcciones configuradas
+func (p *serverPool) size() int {
+    return len(p.endpoints)
+}
+
+// Se conecta a la primera direccion disponible segun candidates.
+// Devuelve la conexion, o un error con la falla de cada direccion
+func (p *serverPool) dial(ID string, metrics *Metrics) (*NationalLotteryCenter, error) {
+    problems := []string{}
+    var last error
+    for _, e := range p.candidates() {
+        center, err := DialNationalLotteryCenterTimeout(ID, e.address, p.timeouts)
+        if err == nil {
+            p.succeeded(e.address)
+            return center, nil
+        }
+
+        metrics.DialRetry()
+        p.failed(e.address, err)
+        problems = append(problems, err.Error())
+        last = err
+    }
+
+    if len(problems) == 1 {
+        return nil, last
+    }
+    // Se envuelve la ultima falla para que siga reconociendose como error de conexion
+    return nil, fmt.Errorf("no lottery center address available: %s; %w", strings.Join(problems[:len(problems)-1], "; "), last)
+}
+
+// Indica si err es una falla de la conexion con la central, despues de
+//  la cual conviene reconectarse, posiblemente a otra direccion. Los
+//  errores al leer el archivo o los rechazos de la central no lo son
+func isConnectionError(err error) bool {
+    var netErr net.Error
+    return errors.As(err, &netErr) ||
+        errors.Is(err, io.EOF) ||
+        errors.Is(err, syscall.ECONNRESET) ||
+        errors.Is(err, syscall.EPIPE)
+}
+
+// Indica si ante err conviene reconectarse a otra direccion de la central
+func (c *Client) canFailover(center *NationalLotteryCenter, err error) bool {
+    return c.spool == nil && c.dryRun == nil && center != nil &&
+        c.servers.size() > 1 && isConnectionError(err)
+}
+
+// Sube una porcion del archivo por center. Si la conexion con la central
+//  falla (incluso si deja de responder por mas de ServerTimeouts.IO) y
+//  hay otra direccion configurada, la direccion queda en cool-down, se
+//  conecta a la siguiente y retoma la porcion desde la ultima apuesta
+//  confirmada: los batches confirmados no se reenvian y el que estaba
+//  en vuelo, sin confirmar, se reenvia completo.
+//
+// La entrega es at-least-once: si la central habia guardado el batch en
+//  vuelo pero su 'O' se perdio, queda registrado dos veces, ya que el
+//  protocolo no permite reconocer un batch repetido.
+//
+// Se abandona si falla en todas las direcciones seguidas sin que se
+//  confirme ningun batch. Devuelve la conexion en uso al terminar
+func (c *Client) uploadShardFailover(ctx context.Context, center *NationalLotteryCenter, s shard, no int, progress *progressStore) (uploadStats, *NationalLotteryCenter, error) {
+    total := uploadStats{}
+    failures := 0
+    for {
+        stats, err := c.uploadShard(ctx, center, s, no, progress)
+        total.add(stats)
+        if err == nil || ctx.Err() != nil || !c.canFailover(center, err) {
+            return total, center, err
+        }
+
+        if stats.batches > 0 {
+            failures = 0
+        }
+        failures++
+        c.servers.failed(center.Address, err)
+        if failures >= c.servers.size() {
+            return total, center, err
+        }
+
+        center.Close()
+        next, dialErr := c.dial()
+        if dialErr != nil {
+            c.logAction("failover", "fail").WithField("shard", no).WithError(dialErr).Error()
+            return total, center, err
+        }
+        c.logAction("failover", "success").WithFields(log.Fields{
+            "shard": no,
+            "from":  center.Address,
+            "to":    next.Address,
+        }).Warn()
+        center = next
+    }
+}
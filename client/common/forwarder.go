@@ -0,0 +1,325 @@
+package common
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+)
+
+// Espera entre intentos de conexion del forwarder si no se configura otra
+const DEFAULT_SPOOL_RETRY = 5 * time.Second
+
+// SpoolConfig configura el spool local donde se guardan las apuestas
+//  antes de enviarlas a la central. Un Dir vacio lo desactiva
+type SpoolConfig struct {
+    Dir   string
+    Retry time.Duration
+}
+
+// Sube las apuestas pasando por el spool: cada porcion del archivo se
+//  agrega al spool en lugar de enviarse, mientras un forwarder en
+//  segundo plano entrega los segmentos sellados a la central. Asi las
+//  apuestas quedan registradas aunque la central no este disponible.
+//
+// Cuando el archivo termino de pasar al spool se espera a que el
+//  forwarder lo vacie, reintentando la conexion cada Spool.Retry, y
+//  recien entonces se envia el 'F'
+func (c *Client) storeAndForward(ctx context.Context, progress *progressStore) error {
+    settings := c.settings()
+    spool, err := OpenSpool(c.config.Spool.Dir, c.config.ID, settings.BatchSize, settings.BatchMaxBytes)
+    if err != nil {
+        c.logAction("open_spool", "fail").WithField("dir", c.config.Spool.Dir).WithError(err).Error()
+        return err
+    }
+    c.spool = spool
+
+    forwarderCtx, stopForwarder := context.WithCancel(ctx)
+    defer stopForwarder()
+
+    stored := make(chan struct{})
+    forwarded := make(chan error, 1)
+    go func() {
+        forwarded <- c.forwardSpool(forwarderCtx, spool, stored)
+    }()
+
+    err = c.uploadBets(ctx, progress)
+    if sealErr := spool.Close(); err == nil {
+        err = sealErr
+    }
+    if err != nil {
+        // Lo que ya esta en el spool se entrega en la proxima ejecucion
+        stopForwarder()
+        <-forwarded
+        return err
+    }
+
+    close(stored)
+    err = <-forwarded
+    if err != nil {
+        return err
+    }
+
+    c.center, err = c.dialRetry(ctx)
+    if err != nil {
+        return err
+    }
+    defer c.center.Close()
+
+    err = c.center.Finish()
+    if err != nil {
+        c.logAction("finishing_connection", "fail").WithError(err).Error()
+        return err
+    }
+
+    err = progress.finish()
+    if err != nil {
+        c.logAction("save_progress", "fail").WithField("file_name", c.config.ProgressFile).WithError(err).Error()
+    }
+    return nil
+}
+
+// Agrega al spool las apuestas de una porcion del archivo que todavia
+//  no fueron agregadas, de a BatchSize. En el progreso se registran como
+//  confirmadas las apuestas que ya estan en el spool
+func (c *Client) spoolShard(ctx context.Context, s shard, no int, progress *progressStore) (uploadStats, error) {
+    stats := uploadStats{}
+
+    reader, err := openBetReader(c.config.BetsFile, s)
+    if err != nil {
+        c.logAction("abrir_archivo", "fail").WithField("file_name", c.config.BetsFile).WithError(err).Error()
+        return stats, err
+    }
+    defer reader.Close()
+
+    confirmed := progress.confirmed(no)
+    if err := skipBets(reader, confirmed); err != nil {
+        c.logAction("resume_upload", "fail").WithField("shard", no).WithError(err).Error()
+        return stats, err
+    }
+    c.metrics.BetsRead(confirmed)
+
+    for eof := false; !eof; {
+        if ctx.Err() != nil {
+            return stats, ctx.Err()
+        }
+
+        batch := []Bet{}
+        size := TL_LENGTH
+        for uint(len(batch)) < c.settings().BatchSize {
+            bet, err := reader.Read()
+            if err == io.EOF {
+                eof = true
+                break
+            }
+            if err != nil {
+                c.logAction("read_record", "fail").WithField("shard", no).WithError(err).Error()
+                return stats, err
+            }
+            batch = append(batch, bet)
+            size += encodedBetSize(c.config.ID, bet)
+        }
+        if len(batch) == 0 {
+            break
+        }
+
+        err = c.spool.Append(batch)
+        if err != nil {
+            c.logAction("spool_bets", "fail").WithField("shard", no).WithError(err).Error()
+            return stats, err
+        }
+        c.metrics.BetsRead(len(batch))
+
+        err = progress.confirm(no, len(batch))
+        if err != nil {
+            c.logAction("save_progress", "fail").WithField("file_name", c.config.ProgressFile).WithError(err).Error()
+            return stats, err
+        }
+
+        stats.batches++
+        stats.bets += len(batch)
+        stats.bytes += size
+        c.logAction("spool_bets", "success").WithFields(log.Fields{
+            "shard":    no,
+            "apuestas": len(batch),
+        }).Debug()
+    }
+    return stats, nil
+}
+
+// Entrega a la central los segmentos sellados del spool, de a uno y en
+//  orden. Cada segmento se envia como un batch y se borra recien cuando
+//  la central lo confirma con 'O'; si la conexion falla el segmento se
+//  conserva y se reintenta cada Spool.Retry.
+//
+// Un segmento corrupto se aparta con la extension .corrupt y se sigue con
+//  los demas, pero al terminar se devuelve un error mientras quede alguno:
+//  sus apuestas no llegaron a la central y no debe enviarse el 'F'.
+//
+// Termina cuando stored se cierra y el spool queda vacio, o cuando ctx
+//  se cancela
+func (c *Client) forwardSpool(ctx context.Context, spool *Spool, stored <-chan struct{}) error {
+    var center *NationalLotteryCenter
+    defer func() {
+        if center != nil {
+            center.Close()
+        }
+    }()
+
+    forwarded := 0
+    for {
+        segments, err := spool.segments()
+        if err != nil {
+            c.logAction("forward_spool", "fail").WithError(err).Error()
+            return err
+        }
+
+        if len(segments) == 0 {
+            select {
+            case <-stored:
+                // Un segmento pudo sellarse justo antes del cierre
+                if segments, err := spool.segments(); err == nil && len(segments) > 0 {
+                    continue
+                }
+                // Las apuestas de un segmento corrupto no llegaron a la
+                //  central, por lo que no se puede enviar el 'F'
+                corrupt, err := spool.corrupt()
+                if err == nil && len(corrupt) > 0 {
+                    err = fmt.Errorf("%w: %d segments were not delivered (%s)", ErrSpoolCorrupt, len(corrupt), strings.Join(corrupt, ", "))
+                }
+                if err != nil {
+                    c.logAction("forward_spool", "fail").WithField("segments", forwarded).WithError(err).Error()
+                    return err
+                }
+                c.logAction("forward_spool", "success").WithField("segments", forwarded).Info()
+                return nil
+            case <-spool.sealed:
+                continue
+            case <-ctx.Done():
+                return ctx.Err()
+            }
+        }
+
+        if center == nil {
+            center, err = c.dialRetry(ctx)
+            if err != nil {
+                return err
+            }
+        }
+
+        for _, segment := range segments {
+            err = c.forwardSegment(ctx, center, segment)
+            if err == ErrSpoolCorrupt {
+                c.logAction("forward_segment", "fail").WithField("file_name", segment).WithError(err).Error()
+                if err := os.Rename(segment, segment+SPOOL_CORRUPT_EXT); err != nil {
+                    return err
+                }
+                if err := syncDir(filepath.Dir(segment)); err != nil {
+                    return err
+                }
+                continue
+            }
+            if ctx.Err() != nil {
+                return ctx.Err()
+            }
+            if err != nil {
+                c.logAction("forward_segment", "fail").WithField("file_name", segment).WithError(err).Warn()
+                if isConnectionError(err) {
+                    c.servers.failed(center.Address, err)
+                }
+                center.Close()
+                center = nil
+                select {
+                case <-ctx.Done():
+                    return ctx.Err()
+                case <-time.After(c.spoolRetry()):
+                }
+                break
+            }
+            forwarded++
+        }
+    }
+}
+
+// Envia un segmento del spool como un batch y lo borra una vez confirmado
+func (c *Client) forwardSegment(ctx context.Context, center *NationalLotteryCenter, segment string) error {
+    bets, err := readSegment(segment)
+    if err != nil {
+        return err
+    }
+
+    stop := abortOnShutdown(ctx, center)
+    defer stop()
+
+    size := TL_LENGTH
+    for _, bet := range bets {
+        size += encodedBetSize(c.config.ID, bet)
+    }
+
+    waited, err := c.limiter.wait(ctx, len(bets), size)
+    if err != nil {
+        return err
+    }
+    c.metrics.RateLimited(waited)
+
+    start := time.Now()
+    err = center.sendBatch(bets)
+    if err != nil {
+        return err
+    }
+    c.metrics.BatchSent(len(bets), size)
+
+    err = center.waitConfirmation()
+    if err != nil {
+        return err
+    }
+    c.metrics.BatchConfirmed(time.Since(start))
+
+    // El borrado se baja a disco: si se perdiera en un corte, el segmento
+    //  volveria a enviarse al reiniciar
+    err = os.Remove(segment)
+    if err == nil {
+        err = syncDir(filepath.Dir(segment))
+    }
+    if err != nil {
+        return err
+    }
+    c.logAction("forward_segment", "success").WithFields(log.Fields{
+        "file_name": segment,
+        "apuestas":  len(bets),
+        "bytes":     size,
+    }).Info()
+    return nil
+}
+
+// Abre una conexion con la central reintentando cada Spool.Retry hasta
+//  lograrlo o hasta que ctx se cancele
+func (c *Client) dialRetry(ctx context.Context) (*NationalLotteryCenter, error) {
+    for {
+        center, err := c.dial()
+        if err == nil {
+            return center, nil
+        }
+
+        c.logAction("connect", "fail").WithFields(log.Fields{
+            "retry_in": c.spoolRetry().String(),
+        }).WithError(err).Warn()
+        select {
+        case <-ctx.Done():
+            return nil, ctx.Err()
+        case <-time.After(c.spoolRetry()):
+        }
+    }
+}
+
+func (c *Client) spoolRetry() time.Duration {
+    if c.config.Spool.Retry > 0 {
+        return c.config.Spool.Retry
+    }
+    return DEFAULT_SPOOL_RETRY
+}
@@ -0,0 +1,72 @@
+package common
+
+import (
+    "encoding/binary"
+    "fmt"
+    "io"
+    "strings"
+)
+
+// Escribe en w el frame de forma legible, como un arbol indentado:
+//
+//  [000000] Z batch count=1
+//  [000005]   B bet len=72
+//  [000010]     A agency len=1 "1"
+func WriteFrame(w io.Writer, frame *Frame) error {
+    return writeFrame(w, frame, 0)
+}
+
+func writeFrame(w io.Writer, frame *Frame, depth int) error {
+    _, err := fmt.Fprintf(w, "[%06d] %s%v\n", frame.Offset, strings.Repeat("  ", depth), frame)
+    if err != nil {
+        return err
+    }
+
+    for _, child := range frame.Children {
+        if err := writeFrame(w, child, depth+1); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Describe el frame en una sola linea, sin sus elementos anidados
+func (f *Frame) String() string {
+    return fmt.Sprintf("%c %s%s", printableTag(f.Tag), TagName(f.Tag), describeFrame(f))
+}
+
+// Devuelve el tag si es imprimible, o '?' en caso contrario
+func printableTag(tag byte) byte {
+    if tag < 0x20 || tag > 0x7e {
+        return '?'
+    }
+    return tag
+}
+
+// Describe el largo y el valor de un frame segun su tipo
+func describeFrame(frame *Frame) string {
+    switch {
+    case frame.Tag == BATCH_TYPE || frame.Tag == WINNERS_TYPE:
+        if frame.Length < 0 {
+            return ""
+        }
+        return fmt.Sprintf(" count=%d", frame.Length)
+    case frame.Tag == POLL_TYPE:
+        if len(frame.Value) != 4 {
+            return ""
+        }
+        return fmt.Sprintf(" agency=%d", binary.BigEndian.Uint32(frame.Value))
+    case frame.Tag == BET_TYPE:
+        if frame.Length < 0 {
+            return ""
+        }
+        return fmt.Sprintf(" len=%d", frame.Length)
+    case isFieldType(frame.Tag):
+        if frame.Length < 0 {
+            return ""
+        }
+        return fmt.Sprintf(" len=%d %q", frame.Length, frame.Value)
+    default:
+        return ""
+    }
+}
@@ -0,0 +1,96 @@
+package common
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+
+    log "github.com/sirupsen/logrus"
+)
+
+// Formatos de log soportados
+const (
+    LOG_TEXT = "text"
+    LOG_JSON = "json"
+)
+
+// Campos que se escriben primero, en este orden, en el formato de texto.
+// El error siempre se escribe al final
+var LEADING_LOG_FIELDS = []string{"action", "result", "client_id", "batch_no"}
+
+// Devuelve una entrada de log con los campos action y result
+func logAction(action string, result string) *log.Entry {
+    return log.WithFields(log.Fields{"action": action, "result": result})
+}
+
+// LogAction es logAction para los paquetes que usan al cliente
+func LogAction(action string, result string) *log.Entry {
+    return logAction(action, result)
+}
+
+// Devuelve el formatter de logrus correspondiente al formato dado
+func NewLogFormatter(format string) (log.Formatter, error) {
+    switch format {
+    case LOG_TEXT, "":
+        return &PipeFormatter{TextFormatter: log.TextFormatter{
+            TimestampFormat: "2006-01-02 15:04:05",
+            FullTimestamp: false,
+        }}, nil
+    case LOG_JSON:
+        return &log.JSONFormatter{TimestampFormat: "2006-01-02 15:04:05"}, nil
+    }
+    return nil, fmt.Errorf("unknown log format %q (expected %v or %v)", format, LOG_TEXT, LOG_JSON)
+}
+
+// PipeFormatter escribe los campos de cada entrada dentro del mensaje,
+//  con el formato "action: x | result: y | campo: valor", y luego
+//  delega en TextFormatter. Asi el formato de texto de los logs no
+//  cambia aunque los valores se registren como campos de logrus
+type PipeFormatter struct {
+    log.TextFormatter
+}
+
+func (f *PipeFormatter) Format(entry *log.Entry) ([]byte, error) {
+    if len(entry.Data) == 0 {
+        return f.TextFormatter.Format(entry)
+    }
+
+    parts := []string{}
+    for _, key := range LEADING_LOG_FIELDS {
+        if value, ok := entry.Data[key]; ok {
+            parts = append(parts, fmt.Sprintf("%s: %v", key, value))
+        }
+    }
+
+    keys := []string{}
+    for key := range entry.Data {
+        if key != log.ErrorKey && !isLeadingLogField(key) {
+            keys = append(keys, key)
+        }
+    }
+    sort.Strings(keys)
+    for _, key := range keys {
+        parts = append(parts, fmt.Sprintf("%s: %v", key, entry.Data[key]))
+    }
+
+    if err, ok := entry.Data[log.ErrorKey]; ok {
+        parts = append(parts, fmt.Sprintf("%s: %v", log.ErrorKey, err))
+    }
+    if entry.Message != "" {
+        parts = append(parts, entry.Message)
+    }
+
+    formatted := *entry
+    formatted.Data = log.Fields{}
+    formatted.Message = strings.Join(parts, " | ")
+    return f.TextFormatter.Format(&formatted)
+}
+
+func isLeadingLogField(key string) bool {
+    for _, leading := range LEADING_LOG_FIELDS {
+        if key == leading {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,167 @@
+package common
+
+import (
+    "fmt"
+    "io"
+    "math"
+    "net/http"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Limites superiores, en segundos, de los buckets del histograma de
+//  latencia de las confirmaciones
+var ACK_LATENCY_BUCKETS = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics son los contadores del cliente, expuestos por HTTP en el
+//  formato de texto de Prometheus. Todos los metodos pueden llamarse
+//  concurrentemente desde las distintas conexiones
+type Metrics struct {
+    // Los campos de 64 bits van primero para que queden alineados
+    //  en plataformas de 32 bits, como pide sync/atomic
+    betsRead         uint64
+    betsSent         uint64
+    batchesConfirmed uint64
+    bytesSent        uint64
+    dialRetries      uint64
+    polls            uint64
+    backoff          uint64
+    rateLimited      uint64
+
+    agency  string
+    latency histogram
+}
+
+// Histograma acumulativo con buckets fijos
+type histogram struct {
+    mu      sync.Mutex
+    buckets []float64
+    counts  []uint64
+    sum     float64
+    count   uint64
+}
+
+// Crea los contadores del cliente de la agencia dada
+func NewMetrics(agency string) *Metrics {
+    return &Metrics{
+        agency: agency,
+        latency: histogram{
+            buckets: ACK_LATENCY_BUCKETS,
+            counts:  make([]uint64, len(ACK_LATENCY_BUCKETS)),
+        },
+    }
+}
+
+// Registra n apuestas leidas del archivo
+func (m *Metrics) BetsRead(n int) {
+    atomic.AddUint64(&m.betsRead, uint64(n))
+}
+
+// Registra un batch de bets apuestas y bytes bytes escrito en la conexion
+func (m *Metrics) BatchSent(bets int, bytes int) {
+    atomic.AddUint64(&m.betsSent, uint64(bets))
+    atomic.AddUint64(&m.bytesSent, uint64(bytes))
+}
+
+// Registra la confirmacion de un batch y cuanto tardo en llegar
+func (m *Metrics) BatchConfirmed(latency time.Duration) {
+    atomic.AddUint64(&m.batchesConfirmed, 1)
+    m.latency.observe(latency.Seconds())
+}
+
+// Registra un intento fallido de conexion con la central
+func (m *Metrics) DialRetry() {
+    atomic.AddUint64(&m.dialRetries, 1)
+}
+
+// Registra cuanto espero un batch por el limite de velocidad de subida
+func (m *Metrics) RateLimited(wait time.Duration) {
+    if wait > 0 {
+        atomic.AddUint64(&m.rateLimited, uint64(wait))
+    }
+}
+
+// Registra una consulta de ganadores
+func (m *Metrics) Poll() {
+    atomic.AddUint64(&m.polls, 1)
+}
+
+// Registra la espera actual entre consultas de ganadores
+func (m *Metrics) Backoff(wait time.Duration) {
+    atomic.StoreUint64(&m.backoff, math.Float64bits(wait.Seconds()))
+}
+
+func (h *histogram) observe(value float64) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    for i, bound := range h.buckets {
+        if value <= bound {
+            h.counts[i]++
+        }
+    }
+    h.sum += value
+    h.count++
+}
+
+// Escribe las metricas en el formato de texto de Prometheus
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+    out := &countingWriter{w: w}
+    labels := fmt.Sprintf(`agency="%s"`, m.agency)
+
+    counter := func(name string, help string, value uint64) {
+        fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s counter\n%s{%s} %d\n", name, help, name, name, labels, value)
+    }
+    counter("tp0_client_bets_read_total", "Bets read from the bets file.", atomic.LoadUint64(&m.betsRead))
+    counter("tp0_client_bets_sent_total", "Bets written to the lottery center.", atomic.LoadUint64(&m.betsSent))
+    counter("tp0_client_batches_confirmed_total", "Batches confirmed by the lottery center.", atomic.LoadUint64(&m.batchesConfirmed))
+    counter("tp0_client_bytes_sent_total", "Bytes of batches written to the lottery center.", atomic.LoadUint64(&m.bytesSent))
+    counter("tp0_client_dial_retries_total", "Failed attempts to connect to the lottery center.", atomic.LoadUint64(&m.dialRetries))
+    counter("tp0_client_polls_total", "Winner polls sent to the lottery center.", atomic.LoadUint64(&m.polls))
+
+    name := "tp0_client_rate_limit_wait_seconds_total"
+    fmt.Fprintf(out, "# HELP %s Time batches waited for the upload rate limit.\n# TYPE %s counter\n", name, name)
+    fmt.Fprintf(out, "%s{%s} %g\n", name, labels, time.Duration(atomic.LoadUint64(&m.rateLimited)).Seconds())
+
+    fmt.Fprintf(out, "# HELP tp0_client_poll_backoff_seconds Current wait between winner polls.\n")
+    fmt.Fprintf(out, "# TYPE tp0_client_poll_backoff_seconds gauge\n")
+    fmt.Fprintf(out, "tp0_client_poll_backoff_seconds{%s} %g\n", labels, math.Float64frombits(atomic.LoadUint64(&m.backoff)))
+
+    m.latency.mu.Lock()
+    defer m.latency.mu.Unlock()
+
+    name = "tp0_client_ack_latency_seconds"
+    fmt.Fprintf(out, "# HELP %s Time from sending a batch until it is confirmed.\n# TYPE %s histogram\n", name, name)
+    for i, bound := range m.latency.buckets {
+        fmt.Fprintf(out, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, bound, m.latency.counts[i])
+    }
+    fmt.Fprintf(out, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, m.latency.count)
+    fmt.Fprintf(out, "%s_sum{%s} %g\n", name, labels, m.latency.sum)
+    fmt.Fprintf(out, "%s_count{%s} %d\n", name, labels, m.latency.count)
+
+    return out.n, out.err
+}
+
+// Sirve las metricas por HTTP
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+    m.WriteTo(w)
+}
+
+// Writer que cuenta los bytes escritos y recuerda el primer error
+type countingWriter struct {
+    w   io.Writer
+    n   int64
+    err error
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+    if c.err != nil {
+        return 0, c.err
+    }
+    n, err := c.w.Write(b)
+    c.n += int64(n)
+    c.err = err
+    return n, err
+}
@@ -1,10 +1,14 @@
 package common
 
 import(
+    "bufio"
     "net"
     "encoding/binary"
     "errors"
+    "fmt"
     "strconv"
+    "sync"
+    "time"
 
     log "github.com/sirupsen/logrus"
 )
@@ -29,30 +33,82 @@ const ERROR = -1
 const WAIT = 0
 const INFO = 1
 
+// Esperas maximas por defecto para conectarse a la central y para cada
+//  envio o respuesta (ver ServerTimeouts)
+const DEFAULT_DIAL_TIMEOUT = 5 * time.Second
+const DEFAULT_IO_TIMEOUT = 30 * time.Second
+
+// ServerTimeouts limita cuanto se espera a la central. Dial acota la
+//  conexion e IO cada operacion sobre ella: el envio de un batch, la
+//  espera de su confirmacion, el 'F' y cada consulta de ganadores.
+//  Vencido el plazo la operacion falla con un error de red, por lo que
+//  una central que deja de responder sin cerrar la conexion se trata
+//  como caida. En 0 no se aplican
+type ServerTimeouts struct {
+    Dial time.Duration
+    IO   time.Duration
+}
+
 // Entidad que maneja la comunicacion con el centro de loteria nacional
 type NationalLotteryCenter struct {
     conn net.Conn 
+    writer *bufio.Writer
     ID string
+    // Direccion a la que se conecto, vacia en el modo dry-run
+    Address string
+
+    ioTimeout time.Duration
+    // Deadline fijado con SetDeadline, que las operaciones no extienden
+    mu    sync.Mutex
+    limit time.Time
 }
 
-// Crea el comunicador con la central. genera un socket tcp/ip
-//  con el que se comunicara con el servidor
+// Crea el comunicador con la central. Abre la conexion con el servidor
+//  con el transport del esquema de ServerAddress (ver ParseServerAddress)
 func NewNationalLotteryCenter(ID string, ServerAddress string) *NationalLotteryCenter {
-    conn, err := net.Dial("tcp", ServerAddress)
+    center, err := DialNationalLotteryCenter(ID, ServerAddress)
+    if err != nil {
+        logAction("connect", "fail").WithField("client_id", ID).WithError(err).Fatal()
+    }
+
+    return center
+}
+
+// Igual que NewNationalLotteryCenter, pero si no se puede establecer
+//  la conexion devuelve el error en lugar de terminar el programa.
+//  Usa DEFAULT_DIAL_TIMEOUT y DEFAULT_IO_TIMEOUT
+func DialNationalLotteryCenter(ID string, ServerAddress string) (*NationalLotteryCenter, error) {
+    return DialNationalLotteryCenterTimeout(ID, ServerAddress, ServerTimeouts{
+        Dial: DEFAULT_DIAL_TIMEOUT,
+        IO:   DEFAULT_IO_TIMEOUT,
+    })
+}
+
+// Igual que DialNationalLotteryCenter, con las esperas maximas de timeouts
+func DialNationalLotteryCenterTimeout(ID string, ServerAddress string, timeouts ServerTimeouts) (*NationalLotteryCenter, error) {
+    conn, err := dialTransport(ServerAddress, timeouts.Dial)
     if err != nil {
-        log.Fatalf(
-            "action: connect | result: fail | client_id: %v | error: %v",
-            ID,
-            err,
-        )
+        return nil, err
     }
 
-    center := &NationalLotteryCenter{
+    center := newNationalLotteryCenter(ID, conn)
+    center.Address = ServerAddress
+    center.ioTimeout = timeouts.IO
+    return center, nil
+}
+
+// Devuelve una entrada de log con los campos action, result y client_id
+func (p *NationalLotteryCenter) logAction(action string, result string) *log.Entry {
+    return logAction(action, result).WithField("client_id", p.ID)
+}
+
+// Crea el comunicador con la central sobre una conexion ya establecida
+func newNationalLotteryCenter(ID string, conn net.Conn) *NationalLotteryCenter {
+    return &NationalLotteryCenter{
         conn: conn,
+        writer: bufio.NewWriter(connWriter{conn}),
         ID: ID,
     }
-
-    return center
 }
 
 // Envia todos los bytes en data por la conexióon conn.
@@ -72,42 +128,6 @@ func sendData(conn net.Conn, data []byte) error {
     return nil
 }
 
-// Serializa un campo string a bytes, y por delante pone
-//  el indicador del tipo siguiente el protocolo TLV propuesto
-//
-// Devuelve los bytes serializados
-func serializeString(field_type byte, field string) []byte {
-    serialized := []byte{}
-    serialized = append(serialized, field_type)
-
-    length := make([]byte, 4)
-    binary.BigEndian.PutUint32(length, uint32(len(field)))
-    serialized = append(serialized, length...)
-
-    return append(serialized, []byte(field)...)
-}
-
-// Serializa por completo una apuesta, utilizando el protocolo TLV propuesto
-// El orden de los campos no interesa
-func (p *NationalLotteryCenter) serializeBet(bet Bet) []byte {
-    serialized := []byte{}
-    serialized = append(serialized, serializeString(AGENCY_NAME_TYPE, p.ID)...)
-    serialized = append(serialized, serializeString(NAME_TYPE, bet.Name)...)
-    serialized = append(serialized, serializeString(LAST_NAME_TYPE, bet.Surname)...)
-    serialized = append(serialized, serializeString(DOCUMENT_TYPE, bet.Document)...)
-    serialized = append(serialized, serializeString(BIRTHDATE_TYPE, bet.BirthDate)...)
-    serialized = append(serialized, serializeString(NUMBER_TYPE, bet.Number)...)
-
-    first_part := []byte{}
-    first_part = append(first_part, BET_TYPE)
-    length := make([]byte, 4)
-    binary.BigEndian.PutUint32(length, uint32(len(serialized)))
-    first_part = append(first_part, length...)
-
-    data := append(first_part, serialized...)
-    return data
-}
-
 // Envia una apuesta a traves del socket de comunicacion
 // 
 // La apuesta es serializada segun el protocolo TLV propuesto y enviada
@@ -115,10 +135,10 @@ func (p *NationalLotteryCenter) serializeBet(bet Bet) []byte {
 //
 // Si no hay error se devuelve nil, en caso de error se devuelve este
 func (p *NationalLotteryCenter) sendBet(bet Bet) error {
-    data := p.serializeBet(bet)
-    err := sendData(p.conn, data)
+    encodeBet(p.writer, p.ID, bet)
+    err := p.writer.Flush()
     if err != nil {
-        log.Errorf("action: send_message | result: fail | client_id: %v | error: %v", p.ID, err)
+        p.logAction("send_message", "fail").WithError(err).Error()
         return err
     }
 
@@ -129,24 +149,34 @@ func (p *NationalLotteryCenter) sendBet(bet Bet) error {
 //  del socket de comunicacion
 // El envio se hace en conjunto, es decir los datos se envian
 //  uno tras otro en un tira de bits simultaneamente
+//
+// Las apuestas se serializan directamente sobre el buffer de escritura
+//  de la conexion, sin copias intermedias
 func (p *NationalLotteryCenter) sendBatch(batch []Bet) error {
-    first_part := []byte{}
-    // Se envia el indicador
-    first_part = append(first_part, BATCH_TYPE)
-    length := make([]byte, 4)
-    // Se envia cuantas apuestas seran enviadas
-    binary.BigEndian.PutUint32(length, uint32(len(batch)))
-    first_part = append(first_part, length...)
-
-    data := []byte{}
+    writer, err := p.beginBatch(len(batch))
+    if err != nil {
+        return err
+    }
+
     for _, bet := range batch {
-        serial_bet := p.serializeBet(bet)
-        data = append(data, serial_bet...)
+        if err := writer.Write(bet); err != nil {
+            return err
+        }
     }
 
-    data = append(first_part, data...)
+    return writer.Close()
+}
+
+// Envia un conjunto de apuestas y espera la confirmacion del servidor
+//
+// Si no hay error se devuelve nil, en caso de error se devuelve este
+func (p *NationalLotteryCenter) UploadBatch(batch []Bet) error {
+    err := p.sendBatch(batch)
+    if err != nil {
+        return err
+    }
 
-    return sendData(p.conn, data)
+    return p.waitConfirmation()
 }
 
 // Lee una cantidad especifica de bytes de un socket
@@ -173,10 +203,10 @@ func readAll(conn net.Conn, bytesToRead int) ([]byte, error) {
 // Si ocurre un error en la lectura o  no se leyo lo esperado,
 //  se devuelve un error.
 func (p *NationalLotteryCenter) waitConfirmation() error {
-
+    p.armDeadline()
     confirmation, err := readAll(p.conn, 1) // leer el tipo
     if err != nil {
-        return errors.New("Confirmation error: cannot read TYPE") // probar si funciona %v, err
+        return fmt.Errorf("Confirmation error: cannot read TYPE: %w", err)
     }
 
     if confirmation[0] == byte(OK_TYPE){
@@ -189,12 +219,34 @@ func (p *NationalLotteryCenter) waitConfirmation() error {
 // Envia por el socket el byte correspondiente a cortar la comunicacion
 //  segun lo establecido en el protocolo TLV propuesto
 func (p *NationalLotteryCenter) Finish() error {
-    goodbye := []byte{}
-    goodbye = append(goodbye, FINISH_TYPE)
+    p.armDeadline()
+    p.writer.WriteByte(FINISH_TYPE)
+    return p.writer.Flush()
+}
 
-    err := sendData(p.conn, goodbye)
-    return err
+// Fija un limite de tiempo para las lecturas y escrituras en curso
+//  y futuras sobre la conexion
+func (p *NationalLotteryCenter) SetDeadline(t time.Time) error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.limit = t
+    return p.conn.SetDeadline(t)
+}
+
+// Fija el deadline de la operacion que comienza: ioTimeout desde ahora,
+//  sin pasar el limite fijado con SetDeadline
+func (p *NationalLotteryCenter) armDeadline() {
+    if p.ioTimeout <= 0 {
+        return
+    }
 
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    deadline := time.Now().Add(p.ioTimeout)
+    if !p.limit.IsZero() && p.limit.Before(deadline) {
+        deadline = p.limit
+    }
+    p.conn.SetDeadline(deadline)
 }
 
 // Cierra la conexion con el servidor
@@ -268,19 +320,17 @@ func (p *NationalLotteryCenter) ReadWinners() ([]string, error) {
 //
 // En caso de error sera devuelto como tercer elemento
 func (p *NationalLotteryCenter) PollWinners() (int, []string, error){
-    poll_req := []byte{}
-    poll_req = append(poll_req, POLL_TYPE)
-
-    agency_id := make([]byte, 4)
     id, err := strconv.ParseInt(p.ID, 10, 32)
     if err != nil {
         return ERROR, []string{}, err
     }
 
-    binary.BigEndian.PutUint32(agency_id, uint32(id))
-    poll_req = append(poll_req, agency_id...)
+    // La consulta y su respuesta comparten el deadline
+    p.armDeadline()
 
-    err = sendData(p.conn, poll_req)
+    // ['P' | agency_no:4bytes ]
+    writeHeader(p.writer, POLL_TYPE, int(id))
+    err = p.writer.Flush()
     if err != nil {
         return ERROR, []string{}, err
     }
@@ -0,0 +1,108 @@
+package common
+
+import (
+    "context"
+    "os"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+)
+
+// Sube las apuestas que se van agregando al archivo mientras dura
+//  LoopLapse: el archivo se vuelve a leer al comenzar y luego cada
+//  LoopPeriod, y en cada lectura se suben solo las apuestas nuevas.
+//  La ultima lectura se hace al vencer LoopLapse, y en ella se toma el
+//  archivo completo aunque la ultima linea no termine en un salto de linea.
+//
+// Cada lectura agrega al progreso una porcion con las apuestas nuevas,
+//  por lo que si la subida se interrumpe se retoma con las porciones
+//  guardadas y se sigue leyendo desde el final de la ultima. Las
+//  porciones se suben de a una por la conexion principal del cliente,
+//  que se reemplaza si la central falla (ver uploadShardFailover)
+func (c *Client) uploadPeriodically(ctx context.Context, progress *progressStore) (uploadStats, error) {
+    total := uploadStats{}
+    if c.config.Parallel > 1 {
+        c.logAction("upload", "in_progress").WithField("info", "parallel upload disabled by loop.lapse").Warn()
+    }
+
+    offset := int64(0)
+    shards := progress.shards()
+    if shards != nil {
+        c.logAction("resume_upload", "in_progress").WithField("shards", len(shards)).Info()
+    }
+    for i, s := range shards {
+        stats, center, err := c.uploadShardFailover(ctx, c.center, s, i, progress)
+        c.center = center
+        total.add(stats)
+        if err != nil {
+            return total, err
+        }
+        offset = s.End
+    }
+
+    deadline := time.Now().Add(c.config.LoopLapse)
+    for scan := 1; ; scan++ {
+        last := !time.Now().Before(deadline)
+
+        end, err := lastLineEnd(c.config.BetsFile)
+        if err == nil && last {
+            end, err = fileSize(c.config.BetsFile)
+        }
+        if err != nil {
+            c.logAction("abrir_archivo", "fail").WithField("file_name", c.config.BetsFile).WithError(err).Error()
+            return total, err
+        }
+
+        if end < offset {
+            c.logAction("scan_bets", "fail").WithFields(log.Fields{
+                "scan":   scan,
+                "offset": offset,
+                "size":   end,
+                "info":   "the bets file shrank, only new bets are uploaded",
+            }).Warn()
+        } else if end > offset {
+            no, err := progress.add(shard{Start: offset, End: end})
+            if err != nil {
+                c.logAction("save_progress", "fail").WithField("file_name", c.config.ProgressFile).WithError(err).Error()
+                return total, err
+            }
+
+            stats, center, err := c.uploadShardFailover(ctx, c.center, shard{Start: offset, End: end}, no, progress)
+            c.center = center
+            total.add(stats)
+            if err != nil {
+                return total, err
+            }
+            offset = end
+
+            c.logAction("scan_bets", "success").WithFields(log.Fields{
+                "scan":     scan,
+                "apuestas": stats.bets,
+                "batches":  stats.batches,
+            }).Info()
+        }
+
+        if last {
+            return total, nil
+        }
+
+        wait := c.config.LoopPeriod
+        if remaining := time.Until(deadline); remaining < wait {
+            wait = remaining
+        }
+        select {
+        case <-ctx.Done():
+            return total, ctx.Err()
+        case <-time.After(wait):
+        }
+    }
+}
+
+// Devuelve el tamaño del archivo ubicado en path
+func fileSize(path string) (int64, error) {
+    info, err := os.Stat(path)
+    if err != nil {
+        return 0, err
+    }
+    return info.Size(), nil
+}
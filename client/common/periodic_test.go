@@ -0,0 +1,146 @@
+package common
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+const testBetLine = "Santiago Lionel,Lorca,30904465,1999-03-17,2201\n"
+
+func writeBetsFile(t *testing.T, content string) string {
+    path := filepath.Join(t.TempDir(), "bets.csv")
+    if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+func appendBets(t *testing.T, path string, content string) {
+    file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer file.Close()
+    if _, err := file.WriteString(content); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestLastLineEnd(t *testing.T) {
+    long := make([]byte, 5000)
+    for i := range long {
+        long[i] = 'x'
+    }
+
+    cases := []struct {
+        name    string
+        content string
+        want    int64
+    }{
+        {name: "empty", content: "", want: 0},
+        {name: "no newline", content: "abc", want: 0},
+        {name: "complete lines", content: "ab\ncd\n", want: 6},
+        {name: "partial last line", content: "ab\ncd", want: 3},
+        {name: "partial line longer than a block", content: "ab\n" + string(long), want: 3},
+    }
+
+    for _, c := range cases {
+        got, err := lastLineEnd(writeBetsFile(t, c.content))
+        if err != nil || got != c.want {
+            t.Errorf("%s: got (%v, %v), want %v", c.name, got, err, c.want)
+        }
+    }
+}
+
+// Lee las apuestas de la porcion s del archivo
+func readShard(t *testing.T, path string, s shard) []Bet {
+    reader, err := openBetReader(path, s)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer reader.Close()
+
+    bets := []Bet{}
+    for {
+        bet, err := reader.Read()
+        if err != nil {
+            return bets
+        }
+        bets = append(bets, bet)
+    }
+}
+
+// Cada lectura toma solo las lineas completas nuevas: una linea a medio
+//  escribir se toma recien cuando termina
+func TestDeltaSkipsPartialLine(t *testing.T) {
+    path := writeBetsFile(t, testBetLine+testBetLine+testBetLine[:10])
+
+    first, err := lastLineEnd(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if bets := readShard(t, path, shard{Start: 0, End: first}); len(bets) != 2 {
+        t.Fatalf("first delta: %d bets, want 2", len(bets))
+    }
+
+    appendBets(t, path, testBetLine[10:]+testBetLine)
+    second, err := lastLineEnd(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    bets := readShard(t, path, shard{Start: first, End: second})
+    if len(bets) != 2 {
+        t.Fatalf("second delta: %d bets, want 2", len(bets))
+    }
+    if bets[0].Name != "Santiago Lionel" || bets[0].Number != "2201" {
+        t.Errorf("completed line read as %+v", bets[0])
+    }
+}
+
+// La ultima lectura toma el archivo completo, aunque la ultima linea no
+//  termine en un salto de linea, y cada apuesta se envia una sola vez
+func TestUploadPeriodically(t *testing.T) {
+    path := writeBetsFile(t, testBetLine+testBetLine+testBetLine[:len(testBetLine)-1])
+
+    frames := make(chan *Frame, 16)
+    RegisterTransport("periodic", PipeTransport(fakeCenter(frames)))
+
+    client := NewClient(ClientConfig{
+        ID:              "1",
+        ServerAddresses: []string{"periodic://central"},
+        BetsFile:        path,
+        BatchSize:       10,
+        LoopLapse:       50 * time.Millisecond,
+        LoopPeriod:      10 * time.Millisecond,
+    })
+    center, err := client.dial()
+    if err != nil {
+        t.Fatal(err)
+    }
+    client.center = center
+    defer center.Close()
+
+    progress, err := loadProgress("", path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stats, err := client.uploadPeriodically(context.Background(), progress)
+    if err != nil {
+        t.Fatalf("upload: %v", err)
+    }
+    if stats.bets != 3 {
+        t.Errorf("uploaded %d bets, want 3", stats.bets)
+    }
+
+    center.Close()
+    received := 0
+    for frame := range frames {
+        received += len(frame.Children)
+    }
+    if received != 3 {
+        t.Errorf("center received %d bets, want 3", received)
+    }
+}
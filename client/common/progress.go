@@ -0,0 +1,340 @@
+package common
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "sync"
+)
+
+// ErrStaleProgress indica que el archivo de apuestas cambio desde que se
+//  guardo el progreso, por lo que sus porciones ya no son validas
+var ErrStaleProgress = errors.New("the bets file changed since the upload started")
+
+// Estado de la subida de una porcion del archivo de apuestas
+type shardProgress struct {
+    Start     int64 `json:"start"`
+    End       int64 `json:"end"`
+    Confirmed int   `json:"confirmed"`
+}
+
+// Estado persistido de la subida del archivo de apuestas. Covered y
+//  SHA256 identifican el contenido que abarcan las porciones: los
+//  primeros Covered bytes del archivo y su hash
+type uploadProgress struct {
+    File     string          `json:"file"`
+    Covered  int64           `json:"covered"`
+    SHA256   string          `json:"sha256,omitempty"`
+    Shards   []shardProgress `json:"shards"`
+    Finished bool            `json:"finished"`
+}
+
+// progressStore guarda en disco cuantas apuestas de cada porcion fueron
+//  confirmadas por el servidor, para poder retomar la subida si el
+//  cliente se interrumpe. Un path vacio desactiva la persistencia
+type progressStore struct {
+    mu    sync.Mutex
+    path  string
+    state uploadProgress
+}
+
+// Carga el progreso guardado en path para el archivo de apuestas betsFile.
+// Si no hay progreso guardado, o corresponde a otro archivo, se comienza de cero.
+//
+// Si el contenido que abarcan las porciones guardadas cambio (el archivo
+//  se edito o se reemplazo) devuelve ErrStaleProgress: retomar con esas
+//  porciones podria saltear o partir apuestas. Agregar apuestas al final
+//  del archivo no lo invalida
+func loadProgress(path string, betsFile string) (*progressStore, error) {
+    store := &progressStore{path: path, state: uploadProgress{File: betsFile}}
+    if path == "" {
+        return store, nil
+    }
+
+    state, err := readProgress(path)
+    if os.IsNotExist(err) {
+        return store, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    if state.File != betsFile {
+        return store, nil
+    }
+
+    // Una vez enviado el 'F' ya no quedan apuestas por subir
+    if !state.Finished && state.SHA256 != "" {
+        digest, err := fileDigest(betsFile, state.Covered)
+        if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+            return nil, err
+        }
+        if err != nil || digest != state.SHA256 {
+            return nil, fmt.Errorf("%w (remove %v to upload it from the start)", ErrStaleProgress, path)
+        }
+    }
+    store.state = state
+    return store, nil
+}
+
+// Devuelve el hash sha256 de los primeros size bytes del archivo, o
+//  io.ErrUnexpectedEOF si el archivo es mas corto
+func fileDigest(path string, size int64) (string, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return "", err
+    }
+    defer file.Close()
+
+    hash := sha256.New()
+    if _, err := io.CopyN(hash, file, size); err != nil {
+        if err == io.EOF {
+            return "", io.ErrUnexpectedEOF
+        }
+        return "", err
+    }
+    return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Registra el contenido que abarcan las porciones. Debe llamarse con mu tomado
+func (s *progressStore) cover() error {
+    if s.path == "" {
+        return nil
+    }
+
+    covered := int64(0)
+    for _, sh := range s.state.Shards {
+        if sh.End > covered {
+            covered = sh.End
+        }
+    }
+    digest, err := fileDigest(s.state.File, covered)
+    if err != nil {
+        return err
+    }
+    s.state.Covered = covered
+    s.state.SHA256 = digest
+    return nil
+}
+
+// Lee el archivo de progreso ubicado en path
+func readProgress(path string) (uploadProgress, error) {
+    state := uploadProgress{}
+
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return state, err
+    }
+
+    err = json.Unmarshal(data, &state)
+    return state, err
+}
+
+// Devuelve las porciones guardadas, o nil si la subida no habia comenzado
+func (s *progressStore) shards() []shard {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if len(s.state.Shards) == 0 {
+        return nil
+    }
+
+    shards := make([]shard, len(s.state.Shards))
+    for i, progress := range s.state.Shards {
+        shards[i] = shard{Start: progress.Start, End: progress.End}
+    }
+    return shards
+}
+
+// Registra el comienzo de una subida con las porciones dadas
+func (s *progressStore) start(shards []shard) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.state.Shards = make([]shardProgress, len(shards))
+    for i, sh := range shards {
+        s.state.Shards[i] = shardProgress{Start: sh.Start, End: sh.End}
+    }
+    if err := s.cover(); err != nil {
+        return err
+    }
+    return s.save()
+}
+
+// Agrega una porcion a la subida y devuelve su numero
+func (s *progressStore) add(sh shard) (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.state.Shards = append(s.state.Shards, shardProgress{Start: sh.Start, End: sh.End})
+    if err := s.cover(); err != nil {
+        return 0, err
+    }
+    return len(s.state.Shards) - 1, s.save()
+}
+
+// Devuelve cuantas apuestas de la porcion ya fueron confirmadas
+func (s *progressStore) confirmed(shard int) int {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if shard >= len(s.state.Shards) {
+        return 0
+    }
+    return s.state.Shards[shard].Confirmed
+}
+
+// Registra que el servidor confirmo bets apuestas mas de la porcion
+func (s *progressStore) confirm(shard int, bets int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.state.Shards[shard].Confirmed += bets
+    return s.save()
+}
+
+// Indica si ya se habia enviado el 'F' al servidor
+func (s *progressStore) finished() bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return s.state.Finished
+}
+
+// Registra que se envio el 'F' al servidor
+func (s *progressStore) finish() error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.state.Finished = true
+    return s.save()
+}
+
+// Borra el progreso guardado
+func (s *progressStore) remove() error {
+    if s.path == "" {
+        return nil
+    }
+
+    err := os.Remove(s.path)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    return err
+}
+
+// Escribe el estado en un archivo temporal y luego lo renombra, para que
+//  una interrupcion nunca deje un archivo de progreso a medio escribir
+func (s *progressStore) save() error {
+    if s.path == "" {
+        return nil
+    }
+
+    data, err := json.Marshal(s.state)
+    if err != nil {
+        return err
+    }
+
+    tmp := s.path + ".tmp"
+    file, err := os.Create(tmp)
+    if err != nil {
+        return err
+    }
+
+    _, err = file.Write(data)
+    if err == nil {
+        err = file.Sync()
+    }
+    if closeErr := file.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        return err
+    }
+
+    return os.Rename(tmp, s.path)
+}
+
+// ShardStatus es el estado de subida de una porcion del archivo
+type ShardStatus struct {
+    Start     int64
+    End       int64
+    Bets      int
+    Confirmed int
+}
+
+// UploadStatus es el estado de la subida del archivo de apuestas
+type UploadStatus struct {
+    File         string
+    ProgressFile string
+    Started      bool
+    Finished     bool
+    Shards       []ShardStatus
+}
+
+// Devuelve el estado de la subida segun el progreso guardado,
+//  contando cuantas apuestas tiene cada porcion del archivo
+func (c *Client) Status() (UploadStatus, error) {
+    status := UploadStatus{File: c.config.BetsFile, ProgressFile: c.config.ProgressFile}
+
+    progress, err := loadProgress(c.config.ProgressFile, c.config.BetsFile)
+    if err != nil {
+        return status, err
+    }
+
+    shards := progress.shards()
+    status.Started = shards != nil
+    status.Finished = progress.finished()
+
+    for i, s := range shards {
+        bets, err := countBets(c.config.BetsFile, s)
+        if err != nil {
+            return status, err
+        }
+
+        status.Shards = append(status.Shards, ShardStatus{
+            Start:     s.Start,
+            End:       s.End,
+            Bets:      bets,
+            Confirmed: progress.confirmed(i),
+        })
+    }
+    return status, nil
+}
+
+// Cuenta las apuestas de una porcion del archivo
+func countBets(path string, s shard) (int, error) {
+    reader, err := openBetReader(path, s)
+    if err != nil {
+        return 0, err
+    }
+    defer reader.Close()
+
+    count := 0
+    for {
+        _, err := reader.Read()
+        if err == io.EOF {
+            return count, nil
+        }
+        if err != nil {
+            return count, err
+        }
+        count++
+    }
+}
+
+// Descarta las primeras n apuestas de reader
+func skipBets(reader *betReader, n int) error {
+    for i := 0; i < n; i++ {
+        if _, err := reader.Read(); err != nil {
+            if err == io.EOF {
+                return io.ErrUnexpectedEOF
+            }
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,147 @@
+package common
+
+import (
+    "errors"
+    "io"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestProgressRoundTrip(t *testing.T) {
+    bets := writeBetsFile(t, testBetLine+testBetLine+testBetLine+testBetLine)
+    path := filepath.Join(t.TempDir(), "bets.progress")
+
+    progress, err := loadProgress(path, bets)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if progress.shards() != nil {
+        t.Fatal("new progress should have no shards")
+    }
+
+    shards, err := splitShards(bets, 2)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := progress.start(shards); err != nil {
+        t.Fatal(err)
+    }
+    if err := progress.confirm(0, 1); err != nil {
+        t.Fatal(err)
+    }
+    if err := progress.confirm(1, 2); err != nil {
+        t.Fatal(err)
+    }
+
+    resumed, err := loadProgress(path, bets)
+    if err != nil {
+        t.Fatal(err)
+    }
+    got := resumed.shards()
+    if len(got) != len(shards) {
+        t.Fatalf("resumed %d shards, want %d", len(got), len(shards))
+    }
+    for i := range shards {
+        if got[i] != shards[i] {
+            t.Errorf("shard %d: got %+v, want %+v", i, got[i], shards[i])
+        }
+    }
+    if resumed.confirmed(0) != 1 || resumed.confirmed(1) != 2 {
+        t.Errorf("confirmed (%d, %d), want (1, 2)", resumed.confirmed(0), resumed.confirmed(1))
+    }
+
+    // Otro archivo de apuestas comienza de cero
+    other, err := loadProgress(path, writeBetsFile(t, testBetLine))
+    if err != nil || other.shards() != nil {
+        t.Errorf("progress of another file: got (%v, %v), want no shards", other.shards(), err)
+    }
+}
+
+func TestProgressRejectsChangedFile(t *testing.T) {
+    bets := writeBetsFile(t, testBetLine+testBetLine)
+    path := filepath.Join(t.TempDir(), "bets.progress")
+
+    progress, err := loadProgress(path, bets)
+    if err != nil {
+        t.Fatal(err)
+    }
+    end, err := fileSize(bets)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := progress.start([]shard{{Start: 0, End: end}}); err != nil {
+        t.Fatal(err)
+    }
+
+    // Agregar apuestas al final no invalida el progreso
+    appendBets(t, bets, testBetLine)
+    if _, err := loadProgress(path, bets); err != nil {
+        t.Fatalf("after append: %v", err)
+    }
+
+    // Editar el contenido abarcado si
+    edited := []byte(testBetLine + testBetLine + testBetLine)
+    edited[0] = 'X'
+    if err := os.WriteFile(bets, edited, 0644); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := loadProgress(path, bets); !errors.Is(err, ErrStaleProgress) {
+        t.Errorf("after edit: got %v, want ErrStaleProgress", err)
+    }
+
+    // Y tambien truncarlo
+    if err := os.WriteFile(bets, []byte(testBetLine), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := loadProgress(path, bets); !errors.Is(err, ErrStaleProgress) {
+        t.Errorf("after truncate: got %v, want ErrStaleProgress", err)
+    }
+
+    // Una vez enviado el 'F' el progreso se conserva para consultar ganadores
+    state, err := readProgress(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    finished := &progressStore{path: path, state: state}
+    if err := finished.finish(); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := loadProgress(path, bets); err != nil {
+        t.Errorf("finished upload: %v", err)
+    }
+}
+
+func TestSkipBets(t *testing.T) {
+    bets := writeBetsFile(t, ""+
+        "A,B,1,1999-03-17,1\n"+
+        "A,B,2,1999-03-17,2\n"+
+        "A,B,3,1999-03-17,3\n")
+    end, err := fileSize(bets)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    reader, err := openBetReader(bets, shard{Start: 0, End: end})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer reader.Close()
+
+    if err := skipBets(reader, 2); err != nil {
+        t.Fatal(err)
+    }
+    bet, err := reader.Read()
+    if err != nil || bet.Document != "3" {
+        t.Errorf("after skipping 2: got (%+v, %v), want document 3", bet, err)
+    }
+
+    reader2, err := openBetReader(bets, shard{Start: 0, End: end})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer reader2.Close()
+    if err := skipBets(reader2, 4); !errors.Is(err, io.ErrUnexpectedEOF) {
+        t.Errorf("skipping past the end: got %v, want io.ErrUnexpectedEOF", err)
+    }
+}
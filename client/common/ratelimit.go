@@ -0,0 +1,120 @@
+package common
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+// RateLimitConfig limita la velocidad de subida de las apuestas de la
+//  agencia, en apuestas por segundo y en bytes por segundo. Un limite en
+//  0 no se aplica. Las rafagas permiten enviar de golpe hasta BurstBets
+//  apuestas y BurstBytes bytes acumulados mientras no se envio nada;
+//  en 0 equivalen a un segundo de envio
+type RateLimitConfig struct {
+    BetsPerSecond  uint
+    BytesPerSecond uint
+    BurstBets      uint
+    BurstBytes     uint
+}
+
+// rateLimiter limita los batches enviados por todas las conexiones del
+//  cliente con dos token buckets, uno de apuestas y otro de bytes
+type rateLimiter struct {
+    bets  tokenBucket
+    bytes tokenBucket
+}
+
+// Crea un rateLimiter con los limites de config
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+    limiter := &rateLimiter{}
+    limiter.update(config)
+    return limiter
+}
+
+// Aplica nuevos limites. Los tokens acumulados se conservan, sin
+//  superar la nueva rafaga
+func (l *rateLimiter) update(config RateLimitConfig) {
+    l.bets.update(config.BetsPerSecond, config.BurstBets)
+    l.bytes.update(config.BytesPerSecond, config.BurstBytes)
+}
+
+// Espera hasta que puedan enviarse bets apuestas que ocupan bytes bytes.
+// Devuelve cuanto espero, o ctx.Err() si ctx se cancela durante la espera
+func (l *rateLimiter) wait(ctx context.Context, bets int, bytes int) (time.Duration, error) {
+    // Ambos buckets se descuentan juntos: la espera es la del mas lento
+    wait := l.bets.take(float64(bets))
+    if bytesWait := l.bytes.take(float64(bytes)); bytesWait > wait {
+        wait = bytesWait
+    }
+    if wait <= 0 {
+        return 0, nil
+    }
+
+    select {
+    case <-ctx.Done():
+        return 0, ctx.Err()
+    case <-time.After(wait):
+        return wait, nil
+    }
+}
+
+// tokenBucket acumula rate tokens por segundo hasta un maximo de burst
+type tokenBucket struct {
+    mu     sync.Mutex
+    rate   float64
+    burst  float64
+    tokens float64
+    last   time.Time
+}
+
+func (b *tokenBucket) update(rate uint, burst uint) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if burst == 0 {
+        burst = rate
+    }
+    // El bucket comienza lleno
+    if b.last.IsZero() {
+        b.tokens = float64(burst)
+        b.last = time.Now()
+    } else {
+        b.refill()
+    }
+    b.rate = float64(rate)
+    b.burst = float64(burst)
+    if b.tokens > b.burst {
+        b.tokens = b.burst
+    }
+}
+
+// Descuenta n tokens y devuelve cuanto hay que esperar para que esten
+//  disponibles. Los tokens pueden quedar negativos: asi un pedido mayor
+//  que la rafaga se cumple esperando, y los pedidos siguientes esperan
+//  detras de el, en orden
+func (b *tokenBucket) take(n float64) time.Duration {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if b.rate == 0 {
+        return 0
+    }
+
+    b.refill()
+    b.tokens -= n
+    if b.tokens >= 0 {
+        return 0
+    }
+    return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Suma los tokens acumulados desde la ultima vez. Debe llamarse con mu tomado
+func (b *tokenBucket) refill() {
+    now := time.Now()
+    b.tokens += now.Sub(b.last).Seconds() * b.rate
+    if b.tokens > b.burst {
+        b.tokens = b.burst
+    }
+    b.last = now
+}
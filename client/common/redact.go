@@ -0,0 +1,194 @@
+package common
+
+import (
+    "fmt"
+    "strings"
+    "unicode/utf8"
+
+    log "github.com/sirupsen/logrus"
+)
+
+// Cantidad de digitos del documento que quedan visibles al enmascararlo
+const VISIBLE_DOCUMENT_DIGITS = 3
+
+// RedactPolicy indica que datos personales se enmascaran en los logs
+//  y en los volcados de frames
+type RedactPolicy struct {
+    Documents  bool
+    Names      bool
+    BirthDates bool
+}
+
+// Politicas predefinidas
+var (
+    REDACT_ALL  = RedactPolicy{Documents: true, Names: true, BirthDates: true}
+    REDACT_NONE = RedactPolicy{}
+)
+
+// Interpreta una politica de enmascarado: "all", "none" o una lista
+//  separada por comas de "document", "name" y "birthdate"
+func ParseRedactPolicy(policy string) (RedactPolicy, error) {
+    switch strings.TrimSpace(policy) {
+    case "all":
+        return REDACT_ALL, nil
+    case "none", "":
+        return REDACT_NONE, nil
+    }
+
+    parsed := RedactPolicy{}
+    for _, item := range strings.Split(policy, ",") {
+        switch strings.TrimSpace(item) {
+        case "document":
+            parsed.Documents = true
+        case "name":
+            parsed.Names = true
+        case "birthdate":
+            parsed.BirthDates = true
+        default:
+            return parsed, fmt.Errorf("unknown redact item %q (expected all, none or a list of document, name and birthdate)", item)
+        }
+    }
+    return parsed, nil
+}
+
+// Devuelve una copia de la apuesta con los datos personales enmascarados
+//  segun la politica
+func (p RedactPolicy) Bet(b Bet) Bet {
+    if p.Documents {
+        b.Document = maskDocument(b.Document)
+    }
+    if p.Names {
+        b.Name = maskName(b.Name)
+        b.Surname = maskName(b.Surname)
+    }
+    if p.BirthDates {
+        b.BirthDate = maskBirthDate(b.BirthDate)
+    }
+    return b
+}
+
+// Devuelve el valor enmascarado de un campo TLV segun su tag
+func (p RedactPolicy) Field(tag byte, value []byte) []byte {
+    switch {
+    case tag == DOCUMENT_TYPE && p.Documents:
+        return []byte(maskDocument(string(value)))
+    case (tag == NAME_TYPE || tag == LAST_NAME_TYPE) && p.Names:
+        return []byte(maskName(string(value)))
+    case tag == BIRTHDATE_TYPE && p.BirthDates:
+        return []byte(maskBirthDate(string(value)))
+    }
+    return value
+}
+
+// Devuelve una copia del frame con los valores de sus campos enmascarados.
+// El enmascarado conserva el largo de cada valor, por lo que los bytes
+//  crudos de la copia tambien se enmascaran y siguen siendo un frame valido
+func (p RedactPolicy) Frame(frame *Frame) *Frame {
+    if p == REDACT_NONE {
+        return frame
+    }
+
+    raw := append([]byte(nil), frame.Raw...)
+    return p.redactFrame(frame, frame.Offset, raw)
+}
+
+func (p RedactPolicy) redactFrame(frame *Frame, base int64, raw []byte) *Frame {
+    redacted := *frame
+
+    start := frame.Offset - base
+    if start >= 0 && start+int64(len(frame.Raw)) <= int64(len(raw)) {
+        redacted.Raw = raw[start : start+int64(len(frame.Raw))]
+    }
+
+    if isFieldType(frame.Tag) && frame.Value != nil {
+        redacted.Value = p.Field(frame.Tag, frame.Value)
+        if len(redacted.Raw) >= TL_LENGTH+len(redacted.Value) {
+            copy(redacted.Raw[TL_LENGTH:], redacted.Value)
+        }
+    }
+
+    if frame.Children != nil {
+        redacted.Children = make([]*Frame, len(frame.Children))
+        for i, child := range frame.Children {
+            redacted.Children[i] = p.redactFrame(child, base, raw)
+        }
+    }
+    return &redacted
+}
+
+// Devuelve una copia de la apuesta con el documento, el nombre, el
+//  apellido y la fecha de nacimiento enmascarados, apta para logs
+func (b Bet) Redacted() Bet {
+    return REDACT_ALL.Bet(b)
+}
+
+// Enmascara todo el documento salvo los ultimos VISIBLE_DOCUMENT_DIGITS bytes
+func maskDocument(document string) string {
+    visible := len(document) - VISIBLE_DOCUMENT_DIGITS
+    if visible < 0 {
+        visible = 0
+    }
+    return strings.Repeat("*", visible) + document[visible:]
+}
+
+// Enmascara todo el nombre salvo la primera letra
+func maskName(name string) string {
+    _, size := utf8.DecodeRuneInString(name)
+    return name[:size] + strings.Repeat("*", len(name)-size)
+}
+
+// Enmascara los digitos de la fecha, conservando los separadores
+func maskBirthDate(date string) string {
+    return strings.Map(func(r rune) rune {
+        if r >= '0' && r <= '9' {
+            return '*'
+        }
+        return r
+    }, date)
+}
+
+// RedactHook es un hook de logrus que enmascara los datos personales de
+//  los campos de cada entrada antes de que se escriba, segun Policy.
+// Reconoce los campos con documentos, nombres y fechas de nacimiento por
+//  su clave, y los valores de tipo Bet y Winner
+type RedactHook struct {
+    Policy RedactPolicy
+}
+
+func (h *RedactHook) Levels() []log.Level {
+    return log.AllLevels
+}
+
+func (h *RedactHook) Fire(entry *log.Entry) error {
+    for key, value := range entry.Data {
+        entry.Data[key] = h.redact(key, value)
+    }
+    return nil
+}
+
+func (h *RedactHook) redact(key string, value interface{}) interface{} {
+    switch v := value.(type) {
+    case Bet:
+        return h.Policy.Bet(v)
+    case Winner:
+        bet := h.Policy.Bet(Bet{Name: v.Name, Surname: v.Surname, Document: v.Document, BirthDate: v.BirthDate})
+        v.Name, v.Surname, v.Document, v.BirthDate = bet.Name, bet.Surname, bet.Document, bet.BirthDate
+        return v
+    case string:
+        switch key {
+        case "dni", "document":
+            if h.Policy.Documents {
+                return maskDocument(v)
+            }
+        case "name", "surname":
+            if h.Policy.Names {
+                return maskName(v)
+            }
+        case "birthdate":
+            if h.Policy.BirthDates {
+                return maskBirthDate(v)
+            }
+        }
+    }
+    return value
+}
@@ -0,0 +1,152 @@
+package common
+
+import (
+    "bufio"
+    "bytes"
+    "strings"
+    "testing"
+)
+
+func TestMaskDocument(t *testing.T) {
+    cases := map[string]string{
+        "30904465": "*****465",
+        "123":      "123",
+        "12":       "12",
+        "":         "",
+    }
+    for document, want := range cases {
+        if got := maskDocument(document); got != want {
+            t.Errorf("maskDocument(%q) = %q, want %q", document, got, want)
+        }
+    }
+}
+
+func TestMaskName(t *testing.T) {
+    cases := map[string]string{
+        "Lorca": "L****",
+        "L":     "L",
+        "":      "",
+        // El enmascarado conserva el largo en bytes
+        "Ñandú": "Ñ*****",
+        "Zoë":   "Z***",
+    }
+    for name, want := range cases {
+        if got := maskName(name); got != want {
+            t.Errorf("maskName(%q) = %q, want %q", name, got, want)
+        }
+        if got := maskName(name); len(got) != len(name) {
+            t.Errorf("maskName(%q) changed the length to %d", name, len(got))
+        }
+    }
+}
+
+func TestMaskBirthDate(t *testing.T) {
+    cases := map[string]string{
+        "1999-03-17": "****-**-**",
+        "17/03/1999": "**/**/****",
+        "":           "",
+    }
+    for date, want := range cases {
+        if got := maskBirthDate(date); got != want {
+            t.Errorf("maskBirthDate(%q) = %q, want %q", date, got, want)
+        }
+    }
+}
+
+func TestParseRedactPolicy(t *testing.T) {
+    cases := []struct {
+        policy string
+        want   RedactPolicy
+        fails  bool
+    }{
+        {policy: "all", want: REDACT_ALL},
+        {policy: " all ", want: REDACT_ALL},
+        {policy: "none", want: REDACT_NONE},
+        {policy: "", want: REDACT_NONE},
+        {policy: "document", want: RedactPolicy{Documents: true}},
+        {policy: "name, birthdate", want: RedactPolicy{Names: true, BirthDates: true}},
+        {policy: "document,name,birthdate", want: REDACT_ALL},
+        {policy: "document,phone", fails: true},
+    }
+
+    for _, c := range cases {
+        got, err := ParseRedactPolicy(c.policy)
+        if c.fails {
+            if err == nil {
+                t.Errorf("%q: expected error", c.policy)
+            }
+            continue
+        }
+        if err != nil || got != c.want {
+            t.Errorf("%q: got (%+v, %v), want %+v", c.policy, got, err, c.want)
+        }
+    }
+}
+
+// Decodifica el batch serializado de las apuestas dadas
+func encodedBatchFrame(t *testing.T, bets []Bet) *Frame {
+    buffer := &bytes.Buffer{}
+    writer := bufio.NewWriter(buffer)
+    writeHeader(writer, BATCH_TYPE, len(bets))
+    for _, bet := range bets {
+        encodeBet(writer, "1", bet)
+    }
+    writer.Flush()
+
+    frame, err := NewFrameDecoder(buffer).Next()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return frame
+}
+
+func TestRedactFrame(t *testing.T) {
+    bet := Bet{Name: "Ñandú", Surname: "Lorca", Document: "30904465", BirthDate: "1999-03-17", Number: "2201"}
+    frame := encodedBatchFrame(t, []Bet{bet})
+    original := append([]byte(nil), frame.Raw...)
+
+    redacted := REDACT_ALL.Frame(frame)
+    if !bytes.Equal(frame.Raw, original) {
+        t.Fatal("redacting modified the original frame")
+    }
+    if len(redacted.Raw) != len(original) {
+        t.Fatalf("redacted frame has %d bytes, want %d", len(redacted.Raw), len(original))
+    }
+    for _, value := range []string{bet.Name, bet.Surname, bet.Document, bet.BirthDate} {
+        if bytes.Contains(redacted.Raw, []byte(value)) {
+            t.Errorf("redacted frame still contains %q", value)
+        }
+    }
+
+    // Los bytes enmascarados siguen siendo un frame valido
+    decoded, err := NewFrameDecoder(bytes.NewReader(redacted.Raw)).Next()
+    if err != nil {
+        t.Fatalf("redacted frame does not decode: %v", err)
+    }
+    raw := string(decoded.Raw)
+    for _, value := range []string{"*****465", "Ñ*****", "L****", "****-**-**", "2201"} {
+        if !strings.Contains(raw, value) {
+            t.Errorf("redacted frame does not contain %q", value)
+        }
+    }
+
+    if got := REDACT_NONE.Frame(frame); got != frame {
+        t.Error("REDACT_NONE should return the same frame")
+    }
+}
+
+func TestValidateMasksPersonalData(t *testing.T) {
+    cases := []Bet{
+        {Name: "A", Surname: "B", Document: "30904x65", BirthDate: "1999-03-17", Number: "1"},
+        {Name: "A", Surname: "B", Document: "30904465", BirthDate: "1999-13-17", Number: "1"},
+    }
+    for _, bet := range cases {
+        err := bet.Validate()
+        if err == nil {
+            t.Fatalf("%+v: expected error", bet)
+        }
+        if strings.Contains(err.Error(), bet.Document) || strings.Contains(err.Error(), bet.BirthDate) {
+            t.Errorf("error %q leaks personal data", err)
+        }
+    }
+}
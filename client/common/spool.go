@@ -0,0 +1,348 @@
+package common
+
+import (
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "hash/crc32"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+
+    log "github.com/sirupsen/logrus"
+)
+
+// Extensiones de los segmentos del spool: el segmento abierto es el
+//  unico que se escribe, los sellados ya no cambian y son los unicos
+//  que lee el forwarder. Un segmento que no pasa la verificacion de
+//  checksums se aparta con la extension .corrupt
+const (
+    SPOOL_OPEN_EXT    = ".open"
+    SPOOL_SEALED_EXT  = ".seg"
+    SPOOL_CORRUPT_EXT = ".corrupt"
+)
+
+// Largo del encabezado de un registro del spool: largo y checksum
+const SPOOL_RECORD_HEADER = 8
+
+var spoolChecksum = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrSpoolCorrupt indica que un registro del spool no paso la
+//  verificacion del checksum o esta incompleto
+var ErrSpoolCorrupt = errors.New("corrupt spool record")
+
+// Spool guarda en disco las apuestas validadas hasta que el forwarder
+//  las entrega a la central.
+//
+// El spool es un directorio de segmentos append-only. Cada segmento es
+//  una secuencia de registros [largo uint32][crc32c uint32][apuesta en
+//  JSON] y guarda a lo sumo un batch: se sella al llegar a maxBets
+//  apuestas o cuando la siguiente apuesta haria superar maxBytes bytes
+//  al serializar el batch. Asi el forwarder envia cada segmento como un
+//  unico batch y lo borra recien cuando la central lo confirma con 'O'
+type Spool struct {
+    mu       sync.Mutex
+    dir      string
+    agency   string
+    maxBets  uint
+    maxBytes uint
+
+    // Segmento abierto, o nil si no hay ninguno
+    active      *os.File
+    activeBets  uint
+    activeBytes uint
+    next        uint64
+
+    // Avisa al forwarder que se sello un segmento
+    sealed chan struct{}
+}
+
+// OpenSpool abre el spool ubicado en dir, creandolo si no existe.
+// Los segmentos que quedaron abiertos por una interrupcion se recuperan:
+//  se descarta el registro incompleto del final, si lo hay, y se sellan
+func OpenSpool(dir string, agency string, maxBets uint, maxBytes uint) (*Spool, error) {
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return nil, err
+    }
+
+    s := &Spool{
+        dir:      dir,
+        agency:   agency,
+        maxBets:  maxBets,
+        maxBytes: maxBytes,
+        next:     1,
+        sealed:   make(chan struct{}, 1),
+    }
+
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        return nil, err
+    }
+    // La secuencia considera tambien los segmentos apartados como
+    //  corruptos (.seg.corrupt), para que uno nuevo nunca los pise
+    for _, entry := range entries {
+        name := entry.Name()
+        sequence, err := strconv.ParseUint(strings.SplitN(name, ".", 2)[0], 10, 64)
+        if err != nil {
+            continue
+        }
+        if sequence >= s.next {
+            s.next = sequence + 1
+        }
+        if filepath.Ext(name) == SPOOL_OPEN_EXT {
+            if err := recoverSegment(filepath.Join(dir, name)); err != nil {
+                return nil, err
+            }
+        }
+    }
+    return s, nil
+}
+
+// Recupera un segmento que quedo abierto: lo trunca en el ultimo
+//  registro completo y lo sella, o lo borra si no tiene registros
+func recoverSegment(path string) error {
+    file, err := os.OpenFile(path, os.O_RDWR, 0644)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    bets, valid, err := readRecords(file)
+    if err != nil && err != ErrSpoolCorrupt {
+        return err
+    }
+    if err == ErrSpoolCorrupt {
+        log.WithFields(log.Fields{
+            "action":    "recover_spool",
+            "result":    "in_progress",
+            "file_name": path,
+            "apuestas":  len(bets),
+            "info":      "incomplete record discarded",
+        }).Warn()
+        if err := file.Truncate(valid); err != nil {
+            return err
+        }
+        if err := file.Sync(); err != nil {
+            return err
+        }
+    }
+
+    if len(bets) == 0 {
+        err = os.Remove(path)
+    } else {
+        err = os.Rename(path, strings.TrimSuffix(path, SPOOL_OPEN_EXT)+SPOOL_SEALED_EXT)
+    }
+    if err != nil {
+        return err
+    }
+    return syncDir(filepath.Dir(path))
+}
+
+// Append agrega las apuestas al spool y las baja a disco antes de
+//  volver. Si alguna apuesta no es valida no se agrega ninguna
+func (s *Spool) Append(bets []Bet) error {
+    for _, bet := range bets {
+        if err := bet.Validate(); err != nil {
+            return fmt.Errorf("document %v: %w", maskDocument(bet.Document), err)
+        }
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, bet := range bets {
+        record, err := encodeRecord(bet)
+        if err != nil {
+            return err
+        }
+
+        size := uint(encodedBetSize(s.agency, bet))
+        if s.active != nil && (s.activeBets >= s.maxBets ||
+            (s.maxBytes > 0 && TL_LENGTH+s.activeBytes+size > s.maxBytes)) {
+            if err := s.seal(); err != nil {
+                return err
+            }
+        }
+        if s.active == nil {
+            if err := s.open(); err != nil {
+                return err
+            }
+        }
+
+        if _, err := s.active.Write(record); err != nil {
+            return err
+        }
+        s.activeBets++
+        s.activeBytes += size
+    }
+
+    if s.active == nil {
+        return nil
+    }
+    return s.active.Sync()
+}
+
+// Seal sella el segmento abierto, para que el forwarder pueda enviarlo
+func (s *Spool) Seal() error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.active == nil {
+        return nil
+    }
+    return s.seal()
+}
+
+// Abre un segmento nuevo. Debe llamarse con mu tomado
+func (s *Spool) open() error {
+    path := filepath.Join(s.dir, fmt.Sprintf("%020d%s", s.next, SPOOL_OPEN_EXT))
+    file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0644)
+    if err != nil {
+        return err
+    }
+    // Sin esto un corte de energia podria perder el segmento entero aunque
+    //  Append ya hubiera bajado sus registros a disco
+    if err := syncDir(s.dir); err != nil {
+        file.Close()
+        return err
+    }
+
+    s.next++
+    s.active = file
+    s.activeBets = 0
+    s.activeBytes = 0
+    return nil
+}
+
+// Sella el segmento abierto. Debe llamarse con mu tomado
+func (s *Spool) seal() error {
+    path := s.active.Name()
+    err := s.active.Sync()
+    if closeErr := s.active.Close(); err == nil {
+        err = closeErr
+    }
+    s.active = nil
+    if err != nil {
+        return err
+    }
+
+    err = os.Rename(path, strings.TrimSuffix(path, SPOOL_OPEN_EXT)+SPOOL_SEALED_EXT)
+    if err != nil {
+        return err
+    }
+    if err := syncDir(s.dir); err != nil {
+        return err
+    }
+
+    select {
+    case s.sealed <- struct{}{}:
+    default:
+    }
+    return nil
+}
+
+// Devuelve, en orden, los segmentos sellados pendientes de envio
+func (s *Spool) segments() ([]string, error) {
+    segments, err := filepath.Glob(filepath.Join(s.dir, "*"+SPOOL_SEALED_EXT))
+    if err != nil {
+        return nil, err
+    }
+    sort.Strings(segments)
+    return segments, nil
+}
+
+// Devuelve los segmentos apartados por no pasar la verificacion
+func (s *Spool) corrupt() ([]string, error) {
+    return filepath.Glob(filepath.Join(s.dir, "*"+SPOOL_CORRUPT_EXT))
+}
+
+// Close sella el segmento abierto
+func (s *Spool) Close() error {
+    return s.Seal()
+}
+
+// Lee las apuestas de un segmento sellado verificando sus checksums
+func readSegment(path string) ([]Bet, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    bets, _, err := readRecords(file)
+    return bets, err
+}
+
+// Lee los registros de r hasta el final. Devuelve las apuestas leidas,
+//  la cantidad de bytes que ocupan los registros validos y
+//  ErrSpoolCorrupt si encontro un registro incompleto o con un
+//  checksum que no coincide
+func readRecords(r io.Reader) ([]Bet, int64, error) {
+    bets := []Bet{}
+    valid := int64(0)
+    header := make([]byte, SPOOL_RECORD_HEADER)
+    for {
+        _, err := io.ReadFull(r, header)
+        if err == io.EOF {
+            return bets, valid, nil
+        }
+        if err == io.ErrUnexpectedEOF {
+            return bets, valid, ErrSpoolCorrupt
+        }
+        if err != nil {
+            return bets, valid, err
+        }
+
+        length := binary.BigEndian.Uint32(header[:4])
+        if length > MAX_FIELD_LENGTH {
+            return bets, valid, ErrSpoolCorrupt
+        }
+        payload := make([]byte, length)
+        if _, err := io.ReadFull(r, payload); err != nil {
+            if err == io.EOF || err == io.ErrUnexpectedEOF {
+                return bets, valid, ErrSpoolCorrupt
+            }
+            return bets, valid, err
+        }
+        if crc32.Checksum(payload, spoolChecksum) != binary.BigEndian.Uint32(header[4:]) {
+            return bets, valid, ErrSpoolCorrupt
+        }
+
+        bet := Bet{}
+        if err := json.Unmarshal(payload, &bet); err != nil {
+            return bets, valid, ErrSpoolCorrupt
+        }
+        bets = append(bets, bet)
+        valid += int64(SPOOL_RECORD_HEADER + len(payload))
+    }
+}
+
+// Serializa una apuesta como registro del spool
+func encodeRecord(bet Bet) ([]byte, error) {
+    payload, err := json.Marshal(bet)
+    if err != nil {
+        return nil, err
+    }
+
+    record := make([]byte, SPOOL_RECORD_HEADER+len(payload))
+    binary.BigEndian.PutUint32(record[:4], uint32(len(payload)))
+    binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, spoolChecksum))
+    copy(record[SPOOL_RECORD_HEADER:], payload)
+    return record, nil
+}
+
+// Baja a disco las entradas del directorio, para que un rename o un
+//  borrado sobrevivan a un corte de energia
+func syncDir(dir string) error {
+    file, err := os.Open(dir)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    return file.Sync()
+}
@@ -0,0 +1,43 @@
+package common
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+// Un segmento nuevo no reutiliza la secuencia de uno apartado como
+//  corrupto, que de lo contrario se pisaria al apartar el nuevo
+func TestOpenSpoolSkipsCorruptSequences(t *testing.T) {
+    dir := t.TempDir()
+    corrupt := filepath.Join(dir, fmt.Sprintf("%020d%s%s", 7, SPOOL_SEALED_EXT, SPOOL_CORRUPT_EXT))
+    if err := os.WriteFile(corrupt, []byte("garbage"), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    spool, err := OpenSpool(dir, "1", 1, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer spool.Close()
+
+    if err := spool.Append([]Bet{benchBet}); err != nil {
+        t.Fatal(err)
+    }
+    if err := spool.Seal(); err != nil {
+        t.Fatal(err)
+    }
+
+    segments, err := spool.segments()
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := filepath.Join(dir, fmt.Sprintf("%020d%s", 8, SPOOL_SEALED_EXT))
+    if len(segments) != 1 || segments[0] != want {
+        t.Errorf("segments = %v, want [%v]", segments, want)
+    }
+    if data, err := os.ReadFile(corrupt); err != nil || string(data) != "garbage" {
+        t.Errorf("corrupt segment changed: %q, %v", data, err)
+    }
+}
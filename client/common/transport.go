@@ -0,0 +1,102 @@
+package common
+
+import (
+    "fmt"
+    "net"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Esquemas de las direcciones de la central que se registran por defecto.
+// Una direccion sin esquema usa TRANSPORT_TCP
+const (
+    TRANSPORT_TCP  = "tcp"
+    TRANSPORT_UNIX = "unix"
+)
+
+// Transport abre una conexion con la central. Recibe la direccion sin el
+//  esquema: "host:puerto" para tcp://host:puerto, o la ruta del socket
+//  para unix:///ruta, y la espera maxima para conectarse (0 sin limite)
+type Transport func(target string, timeout time.Duration) (net.Conn, error)
+
+// Transports registrados, por esquema
+var transports = struct {
+    mu     sync.RWMutex
+    byName map[string]Transport
+}{
+    byName: map[string]Transport{
+        TRANSPORT_TCP: func(target string, timeout time.Duration) (net.Conn, error) {
+            return net.DialTimeout("tcp", target, timeout)
+        },
+        TRANSPORT_UNIX: func(target string, timeout time.Duration) (net.Conn, error) {
+            return net.DialTimeout("unix", target, timeout)
+        },
+    },
+}
+
+// RegisterTransport registra transport para las direcciones con el
+//  esquema scheme (scheme://...), reemplazando al que hubiera
+func RegisterTransport(scheme string, transport Transport) {
+    transports.mu.Lock()
+    defer transports.mu.Unlock()
+    transports.byName[scheme] = transport
+}
+
+// Devuelve los esquemas registrados, ordenados
+func registeredTransports() []string {
+    transports.mu.RLock()
+    defer transports.mu.RUnlock()
+
+    schemes := []string{}
+    for scheme := range transports.byName {
+        schemes = append(schemes, scheme)
+    }
+    sort.Strings(schemes)
+    return schemes
+}
+
+// ParseServerAddress separa una direccion de la central en su esquema y
+//  su destino. Falla si el esquema no esta registrado o el destino esta vacio
+func ParseServerAddress(address string) (string, string, error) {
+    scheme, target := TRANSPORT_TCP, address
+    if i := strings.Index(address, "://"); i >= 0 {
+        scheme, target = address[:i], address[i+len("://"):]
+    }
+
+    transports.mu.RLock()
+    _, ok := transports.byName[scheme]
+    transports.mu.RUnlock()
+    if !ok {
+        return "", "", fmt.Errorf("unknown transport %q in %q (expected one of %v)", scheme, address, strings.Join(registeredTransports(), ", "))
+    }
+    if target == "" {
+        return "", "", fmt.Errorf("missing %v address in %q", scheme, address)
+    }
+    return scheme, target, nil
+}
+
+// Abre una conexion con la central usando el transport del esquema de address
+func dialTransport(address string, timeout time.Duration) (net.Conn, error) {
+    scheme, target, err := ParseServerAddress(address)
+    if err != nil {
+        return nil, err
+    }
+
+    transports.mu.RLock()
+    transport := transports.byName[scheme]
+    transports.mu.RUnlock()
+    return transport(target, timeout)
+}
+
+// PipeTransport devuelve un transport en memoria basado en net.Pipe, util
+//  en pruebas: cada conexion abre un pipe y atiende el otro extremo con
+//  serve en su propia goroutine, que recibe tambien el destino pedido
+func PipeTransport(serve func(target string, conn net.Conn)) Transport {
+    return func(target string, timeout time.Duration) (net.Conn, error) {
+        client, server := net.Pipe()
+        go serve(target, server)
+        return client, nil
+    }
+}
@@ -0,0 +1,86 @@
+package common
+
+import (
+    "net"
+    "testing"
+)
+
+// Central simulada: confirma cada batch con 'O' y devuelve por frames
+//  los frames recibidos hasta el 'F'
+func fakeCenter(frames chan<- *Frame) func(string, net.Conn) {
+    return func(target string, conn net.Conn) {
+        defer conn.Close()
+        defer close(frames)
+
+        decoder := NewFrameDecoder(conn)
+        for {
+            frame, err := decoder.Next()
+            if err != nil {
+                return
+            }
+            frames <- frame
+            if frame.Tag == FINISH_TYPE {
+                return
+            }
+            if _, err := conn.Write([]byte{OK_TYPE}); err != nil {
+                return
+            }
+        }
+    }
+}
+
+func TestPipeTransport(t *testing.T) {
+    frames := make(chan *Frame, 4)
+    RegisterTransport("pipe", PipeTransport(fakeCenter(frames)))
+
+    center, err := DialNationalLotteryCenter("1", "pipe://central")
+    if err != nil {
+        t.Fatalf("dial: %v", err)
+    }
+    defer center.Close()
+    if center.Address != "pipe://central" {
+        t.Errorf("address = %q, want pipe://central", center.Address)
+    }
+
+    if err := center.UploadBatch([]Bet{benchBet, benchBet}); err != nil {
+        t.Fatalf("upload: %v", err)
+    }
+    if err := center.Finish(); err != nil {
+        t.Fatalf("finish: %v", err)
+    }
+
+    want := []byte{BATCH_TYPE, FINISH_TYPE}
+    for _, tag := range want {
+        if got := (<-frames).Tag; got != tag {
+            t.Errorf("frame %q, want %q", got, tag)
+        }
+    }
+}
+
+func TestParseServerAddress(t *testing.T) {
+    cases := []struct {
+        address string
+        scheme  string
+        target  string
+        fails   bool
+    }{
+        {address: "server:12345", scheme: TRANSPORT_TCP, target: "server:12345"},
+        {address: "tcp://server:12345", scheme: TRANSPORT_TCP, target: "server:12345"},
+        {address: "unix:///run/relay.sock", scheme: TRANSPORT_UNIX, target: "/run/relay.sock"},
+        {address: "udp://server:12345", fails: true},
+        {address: "unix://", fails: true},
+    }
+
+    for _, c := range cases {
+        scheme, target, err := ParseServerAddress(c.address)
+        if c.fails {
+            if err == nil {
+                t.Errorf("%q: expected error", c.address)
+            }
+            continue
+        }
+        if err != nil || scheme != c.scheme || target != c.target {
+            t.Errorf("%q: got (%q, %q, %v), want (%q, %q)", c.address, scheme, target, err, c.scheme, c.target)
+        }
+    }
+}
@@ -0,0 +1,85 @@
+package common
+
+import (
+    "encoding/csv"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+)
+
+// Cantidad maxima de problemas detallados en un reporte de validacion
+const MAX_REPORTED_PROBLEMS = 20
+
+// ValidationProblem es una apuesta invalida del archivo
+type ValidationProblem struct {
+    Line int
+    Err  error
+}
+
+// ValidationReport es el resultado de validar un archivo de apuestas
+type ValidationReport struct {
+    File     string
+    Bets     int
+    Invalid  int
+    Problems []ValidationProblem
+}
+
+// Valida todas las apuestas del archivo sin conectarse al servidor.
+// Ademas de los campos de cada apuesta se verifica que entre en un
+//  batch de BatchMaxBytes bytes, si ese limite esta configurado
+//
+// Solo se devuelve error si el archivo no pudo leerse; las apuestas
+//  invalidas se informan en el reporte
+func (c *Client) Validate() (ValidationReport, error) {
+    report := ValidationReport{File: c.config.BetsFile}
+    maxBytes := c.settings().BatchMaxBytes
+
+    file, err := os.Open(c.config.BetsFile)
+    if err != nil {
+        return report, err
+    }
+    defer file.Close()
+
+    reader := csv.NewReader(file)
+    reader.Comma = ','
+    reader.FieldsPerRecord = 5
+
+    for {
+        record, err := reader.Read()
+        if err == io.EOF {
+            return report, nil
+        }
+
+        var parseErr *csv.ParseError
+        if errors.As(err, &parseErr) {
+            report.Bets++
+            report.add(parseErr.Line, parseErr.Err)
+            continue
+        }
+        if err != nil {
+            return report, err
+        }
+
+        report.Bets++
+        line, _ := reader.FieldPos(0)
+        bet := fromRecord(record)
+        if err := bet.Validate(); err != nil {
+            report.add(line, err)
+            continue
+        }
+
+        size := TL_LENGTH + encodedBetSize(c.config.ID, bet)
+        if maxBytes > 0 && uint(size) > maxBytes {
+            report.add(line, fmt.Errorf("bet needs %d bytes, batch_max_bytes is %d", size, maxBytes))
+        }
+    }
+}
+
+// Registra una apuesta invalida, detallando solo los primeros problemas
+func (r *ValidationReport) add(line int, err error) {
+    r.Invalid++
+    if len(r.Problems) < MAX_REPORTED_PROBLEMS {
+        r.Problems = append(r.Problems, ValidationProblem{Line: line, Err: err})
+    }
+}
@@ -0,0 +1,441 @@
+package common
+
+import (
+    "bufio"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "time"
+
+    "github.com/fsnotify/fsnotify"
+    log "github.com/sirupsen/logrus"
+)
+
+// Subdirectorios del directorio vigilado a donde se mueven los archivos
+//  ya procesados
+const (
+    WATCH_DONE_DIR   = "done"
+    WATCH_FAILED_DIR = "failed"
+)
+
+// Estados de un archivo en el journal
+const (
+    JOURNAL_DONE   = "done"
+    JOURNAL_FAILED = "failed"
+)
+
+// Espera antes de reintentar un archivo cuya subida fallo porque la
+//  central no estaba disponible
+const WATCH_RETRY = 5 * time.Second
+
+// WatchConfig configura el modo daemon, que vigila un directorio y sube
+//  cada archivo de apuestas que aparece en el
+type WatchConfig struct {
+    Dir          string
+    Pattern      string
+    Settle       time.Duration
+    Journal      string
+    FinishOnExit bool
+}
+
+// Entrada del journal: el resultado de procesar un archivo
+type journalEntry struct {
+    File   string    `json:"file"`
+    SHA256 string    `json:"sha256"`
+    Status string    `json:"status"`
+    Bets   int       `json:"bets"`
+    Error  string    `json:"error,omitempty"`
+    Time   time.Time `json:"time"`
+}
+
+// journal registra, una linea JSON por archivo, que archivos ya fueron
+//  procesados. Los archivos se identifican por el hash de su contenido,
+//  por lo que un archivo subido no se vuelve a subir aunque el daemon se
+//  reinicie antes de moverlo, o aunque se lo copie de nuevo con otro nombre
+type journal struct {
+    file    *os.File
+    entries map[string]journalEntry
+}
+
+// Abre el journal ubicado en path, creandolo si no existe
+func openJournal(path string) (*journal, error) {
+    file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+    if err != nil {
+        return nil, err
+    }
+
+    j := &journal{file: file, entries: map[string]journalEntry{}}
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        entry := journalEntry{}
+        // Una linea a medio escribir por una interrupcion se descarta
+        if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+            j.entries[entry.SHA256] = entry
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        file.Close()
+        return nil, err
+    }
+    return j, nil
+}
+
+// Devuelve el resultado registrado para el contenido con el hash dado
+func (j *journal) lookup(sha string) (journalEntry, bool) {
+    entry, ok := j.entries[sha]
+    return entry, ok
+}
+
+// Indica si el contenido con el hash dado ya fue subido. Un contenido que
+//  fallo puede volver a procesarse si se lo deja otra vez en el directorio
+func (j *journal) done(sha string) bool {
+    entry, ok := j.lookup(sha)
+    return ok && entry.Status == JOURNAL_DONE
+}
+
+// Agrega una entrada y la baja a disco
+func (j *journal) record(entry journalEntry) error {
+    data, err := json.Marshal(entry)
+    if err != nil {
+        return err
+    }
+
+    if _, err := j.file.Write(append(data, '\n')); err != nil {
+        return err
+    }
+    if err := j.file.Sync(); err != nil {
+        return err
+    }
+    j.entries[entry.SHA256] = entry
+    return nil
+}
+
+func (j *journal) Close() error {
+    return j.file.Close()
+}
+
+// Devuelve el hash sha256 del contenido del archivo
+func hashFile(path string) (string, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return "", err
+    }
+    defer file.Close()
+
+    hash := sha256.New()
+    if _, err := io.Copy(hash, file); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Watch corre el modo daemon: vigila Watch.Dir y sube cada archivo de
+//  apuestas que aparece, de a uno por vez, cada uno por su propia conexion.
+//
+// Un archivo se considera completo cuando paso Watch.Settle sin que se
+//  modifique. Al terminar de subirlo se registra en el journal y se mueve
+//  a done/; si falla se registra el error y se mueve a failed/. Si falla
+//  porque la central no esta disponible no se registra nada: el archivo
+//  queda en el directorio y se reintenta luego de WATCH_RETRY, retomando
+//  desde la ultima apuesta confirmada. Los archivos que ya figuran en el
+//  journal como subidos no se vuelven a subir.
+//
+// Como el servidor cuenta las agencias por los 'F' recibidos, no se envia
+//  un 'F' por archivo: solo se envia al terminar el daemon si
+//  Watch.FinishOnExit esta configurado y no quedan archivos sin subir
+//  (ver stopWatch). Si ctx se cancela durante una subida el progreso
+//  queda guardado y se retoma al reiniciar
+func (c *Client) Watch(ctx context.Context) error {
+    config := c.config.Watch
+    for _, dir := range []string{WATCH_DONE_DIR, WATCH_FAILED_DIR} {
+        if err := os.MkdirAll(filepath.Join(config.Dir, dir), 0755); err != nil {
+            c.logAction("watch", "fail").WithField("dir", config.Dir).WithError(err).Error()
+            return err
+        }
+    }
+
+    journal, err := openJournal(c.journalPath())
+    if err != nil {
+        c.logAction("open_journal", "fail").WithField("file_name", c.journalPath()).WithError(err).Error()
+        return err
+    }
+    defer journal.Close()
+
+    watcher, err := fsnotify.NewWatcher()
+    if err != nil {
+        c.logAction("watch", "fail").WithField("dir", config.Dir).WithError(err).Error()
+        return err
+    }
+    defer watcher.Close()
+
+    if err := watcher.Add(config.Dir); err != nil {
+        c.logAction("watch", "fail").WithField("dir", config.Dir).WithError(err).Error()
+        return err
+    }
+    c.logAction("watch", "in_progress").WithFields(log.Fields{
+        "dir":     config.Dir,
+        "pattern": config.Pattern,
+    }).Info()
+
+    // Ultimo evento de cada archivo pendiente. Los archivos que ya estaban
+    //  en el directorio se toman como pendientes desde el arranque
+    pending := map[string]time.Time{}
+    existing, err := filepath.Glob(filepath.Join(config.Dir, config.Pattern))
+    if err != nil {
+        return err
+    }
+    for _, path := range existing {
+        pending[path] = time.Time{}
+    }
+
+    tick := config.Settle / 2
+    if tick < 100*time.Millisecond {
+        tick = 100 * time.Millisecond
+    }
+    ticker := time.NewTicker(tick)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return c.stopWatch(ctx, pending)
+
+        case event, ok := <-watcher.Events:
+            if !ok {
+                return nil
+            }
+            if matched, _ := filepath.Match(config.Pattern, filepath.Base(event.Name)); !matched {
+                continue
+            }
+            if event.Op&(fsnotify.Create|fsnotify.Write) != 0 {
+                pending[event.Name] = time.Now()
+            }
+            if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+                delete(pending, event.Name)
+            }
+
+        case err, ok := <-watcher.Errors:
+            if !ok {
+                return nil
+            }
+            c.logAction("watch", "fail").WithError(err).Warn()
+
+        case <-ticker.C:
+            for _, path := range completedFiles(pending, config.Settle) {
+                delete(pending, path)
+                retry, err := c.processFile(ctx, journal, path)
+                if ctx.Err() != nil {
+                    // Si la subida se interrumpio el archivo sigue en el directorio
+                    pending[path] = time.Now()
+                    return c.stopWatch(ctx, pending)
+                }
+                if retry {
+                    // Se posterga como si el archivo se hubiera modificado
+                    pending[path] = time.Now().Add(WATCH_RETRY - config.Settle)
+                }
+                if err != nil {
+                    c.logAction("watch", "fail").WithField("file_name", path).WithError(err).Error()
+                }
+            }
+        }
+    }
+}
+
+// Devuelve, ordenados, los archivos pendientes que no se modificaron
+//  durante settle
+func completedFiles(pending map[string]time.Time, settle time.Duration) []string {
+    completed := []string{}
+    for path, last := range pending {
+        info, err := os.Stat(path)
+        if err != nil {
+            delete(pending, path)
+            continue
+        }
+        if info.Mode().IsRegular() && time.Since(last) >= settle && time.Since(info.ModTime()) >= settle {
+            completed = append(completed, path)
+        }
+    }
+    sort.Strings(completed)
+    return completed
+}
+
+// Sube un archivo completo y lo mueve a done/ o failed/ segun el resultado.
+// Si la central no estaba disponible el archivo se deja en su lugar y
+//  devuelve true para reintentarlo. Solo devuelve error si no pudo
+//  registrarse o moverse el archivo
+func (c *Client) processFile(ctx context.Context, journal *journal, path string) (bool, error) {
+    sha, err := hashFile(path)
+    if err != nil {
+        return false, err
+    }
+
+    if journal.done(sha) {
+        c.logAction("watch_file", "skipped").WithFields(log.Fields{
+            "file_name": path,
+            "reason":    "already " + JOURNAL_DONE,
+        }).Info()
+        return false, moveProcessed(path, c.config.Watch.Dir, JOURNAL_DONE)
+    }
+
+    entry := journalEntry{File: filepath.Base(path), SHA256: sha, Status: JOURNAL_DONE}
+    bets, err := c.uploadFile(ctx, path, sha)
+    if ctx.Err() != nil {
+        c.logAction("watch_file", "interrupted").WithField("file_name", path).Info()
+        return false, nil
+    }
+    if err != nil && isConnectionError(err) {
+        c.logAction("watch_file", "fail").WithFields(log.Fields{
+            "file_name": path,
+            "retry_in":  WATCH_RETRY.String(),
+        }).WithError(err).Warn()
+        return true, nil
+    }
+
+    entry.Bets = bets
+    entry.Time = time.Now()
+    if err != nil {
+        entry.Status = JOURNAL_FAILED
+        entry.Error = err.Error()
+        c.logAction("watch_file", "fail").WithField("file_name", path).WithError(err).Error()
+    } else {
+        c.logAction("watch_file", "success").WithFields(log.Fields{
+            "file_name": path,
+            "apuestas":  bets,
+        }).Info()
+    }
+
+    if err := journal.record(entry); err != nil {
+        return false, err
+    }
+    // El progreso se borra recien despues de registrar el archivo: si el
+    //  daemon se corta antes, al reiniciar la subida se retoma con todas
+    //  las apuestas ya confirmadas y no se reenvia ninguna
+    if err := os.Remove(c.watchProgressPath(sha)); err != nil && !os.IsNotExist(err) {
+        return false, err
+    }
+    return false, moveProcessed(path, c.config.Watch.Dir, entry.Status)
+}
+
+// Sube un archivo de apuestas sin enviar el 'F', con una copia del
+//  cliente configurada para ese archivo. El progreso de la subida se
+//  guarda junto al journal, en un archivo propio del contenido sha, para
+//  poder retomarla tras un reinicio aunque mientras tanto aparezcan otros
+//  archivos. Lo borra processFile una vez registrado el resultado
+func (c *Client) uploadFile(ctx context.Context, path string, sha string) (int, error) {
+    config := c.settings()
+    config.BetsFile = path
+    config.ProgressFile = c.watchProgressPath(sha)
+    // Los archivos se suben una vez completos, sin releerlos
+    config.LoopLapse = 0
+
+    file := &Client{config: config, metrics: c.metrics, limiter: c.limiter, servers: c.servers}
+    progress, err := loadProgress(config.ProgressFile, path)
+    if err != nil {
+        return 0, err
+    }
+
+    file.center, err = file.dial()
+    if err != nil {
+        file.logAction("connect", "fail").WithError(err).Error()
+        return 0, err
+    }
+    defer file.center.Close()
+
+    err = file.uploadBets(ctx, progress)
+    return file.uploaded.bets, err
+}
+
+// Mueve un archivo procesado al subdirectorio de su estado. Si ya existe
+//  un archivo con ese nombre se le agrega un sufijo con la fecha
+func moveProcessed(path string, dir string, status string) error {
+    subdir := WATCH_DONE_DIR
+    if status == JOURNAL_FAILED {
+        subdir = WATCH_FAILED_DIR
+    }
+
+    target := filepath.Join(dir, subdir, filepath.Base(path))
+    if _, err := os.Stat(target); err == nil {
+        target = fmt.Sprintf("%s.%s", target, time.Now().Format("20060102T150405.000"))
+    }
+    return os.Rename(path, target)
+}
+
+// Termina el daemon: si esta configurado envia el 'F' a la central.
+// Como con el 'F' el servidor puede hacer el sorteo, no se envia si queda
+//  algun archivo sin subir (ver unfinishedFiles): sus apuestas quedarian
+//  afuera. En ese caso solo se loguea una advertencia.
+// Devuelve ctx.Err() para que el proceso salga con el estado de la señal
+func (c *Client) stopWatch(ctx context.Context, pending map[string]time.Time) error {
+    if !c.config.Watch.FinishOnExit {
+        c.logAction("watch", "interrupted").Info()
+        return ctx.Err()
+    }
+
+    unfinished, err := c.unfinishedFiles(pending)
+    if err != nil {
+        c.logAction("finishing_connection", "fail").WithError(err).Error()
+        return err
+    }
+    if len(unfinished) > 0 {
+        c.logAction("finishing_connection", "skipped").WithFields(log.Fields{
+            "reason": "files not uploaded yet",
+            "files":  unfinished,
+        }).Warn()
+        return ctx.Err()
+    }
+
+    center, err := c.dial()
+    if err != nil {
+        c.logAction("connect", "fail").WithError(err).Error()
+        return err
+    }
+    defer center.Close()
+
+    if err := center.Finish(); err != nil {
+        c.logAction("finishing_connection", "fail").WithError(err).Error()
+        return err
+    }
+    c.logAction("watch", "success").WithField("info", "finish sent").Info()
+    return ctx.Err()
+}
+
+// Devuelve, ordenados, los archivos cuya subida no termino: los pendientes
+//  que siguen en el directorio, ya sea esperando a completarse o a un
+//  reintento, y los progresos guardados de subidas interrumpidas
+func (c *Client) unfinishedFiles(pending map[string]time.Time) ([]string, error) {
+    unfinished := []string{}
+    for path := range pending {
+        if _, err := os.Stat(path); err == nil {
+            unfinished = append(unfinished, path)
+        }
+    }
+
+    progress, err := filepath.Glob(c.watchProgressPath("*"))
+    if err != nil {
+        return nil, err
+    }
+    unfinished = append(unfinished, progress...)
+    sort.Strings(unfinished)
+    return unfinished, nil
+}
+
+// Devuelve el path del journal: Watch.Journal, o .journal dentro del
+//  directorio vigilado
+func (c *Client) journalPath() string {
+    if c.config.Watch.Journal != "" {
+        return c.config.Watch.Journal
+    }
+    return filepath.Join(c.config.Watch.Dir, ".journal")
+}
+
+// Devuelve el path donde se guarda el progreso de la subida del
+//  contenido con el hash sha
+func (c *Client) watchProgressPath(sha string) string {
+    return c.journalPath() + "." + sha + ".progress"
+}
@@ -0,0 +1,138 @@
+package common
+
+import (
+    "context"
+    "net"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// Central que corta la conexion al recibir un batch, sin confirmarlo.
+// Avisa por batches cada batch recibido y por finishes cada 'F'
+func droppingCenter(batches chan<- struct{}, finishes chan<- struct{}) func(string, net.Conn) {
+    return func(target string, conn net.Conn) {
+        defer conn.Close()
+
+        frame, err := NewFrameDecoder(conn).Next()
+        if err != nil {
+            return
+        }
+        if frame.Tag == FINISH_TYPE {
+            finishes <- struct{}{}
+        } else {
+            batches <- struct{}{}
+        }
+    }
+}
+
+func watchClient(t *testing.T, scheme string) (*Client, string) {
+    dir := t.TempDir()
+    client := NewClient(ClientConfig{
+        ID:              "1",
+        ServerAddresses: []string{scheme + "://central"},
+        BatchSize:       10,
+        Watch: WatchConfig{
+            Dir:          dir,
+            Pattern:      "*.csv",
+            Settle:       10 * time.Millisecond,
+            FinishOnExit: true,
+        },
+    })
+    return client, dir
+}
+
+// Corre el daemon hasta que ready se cierre y devuelve su resultado
+func runWatch(t *testing.T, client *Client, ready <-chan struct{}) error {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    result := make(chan error, 1)
+    go func() { result <- client.Watch(ctx) }()
+
+    select {
+    case <-ready:
+    case <-time.After(5 * time.Second):
+        t.Fatal("timed out waiting for the daemon")
+    }
+    cancel()
+    return <-result
+}
+
+// Un archivo que espera un reintento no esta subido: al terminar no se
+//  envia el 'F', para que el servidor no haga el sorteo sin sus apuestas
+func TestStopWatchSkipsFinishWithPendingFiles(t *testing.T) {
+    batches := make(chan struct{}, 4)
+    finishes := make(chan struct{}, 4)
+    RegisterTransport("watchdrop", PipeTransport(droppingCenter(batches, finishes)))
+
+    client, dir := watchClient(t, "watchdrop")
+    path := filepath.Join(dir, "a.csv")
+    if err := os.WriteFile(path, []byte(testBetLine+testBetLine), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    ready := make(chan struct{})
+    go func() {
+        <-batches
+        // Se espera a que el daemon registre el fallo y posterge el archivo
+        time.Sleep(100 * time.Millisecond)
+        close(ready)
+    }()
+    if err := runWatch(t, client, ready); err != context.Canceled {
+        t.Errorf("watch returned %v, want %v", err, context.Canceled)
+    }
+
+    select {
+    case <-finishes:
+        t.Error("finish sent with a file pending retry")
+    case <-time.After(200 * time.Millisecond):
+    }
+    if _, err := os.Stat(path); err != nil {
+        t.Errorf("pending file was moved: %v", err)
+    }
+}
+
+func TestStopWatchSendsFinishWhenIdle(t *testing.T) {
+    batches := make(chan struct{}, 4)
+    finishes := make(chan struct{}, 4)
+    RegisterTransport("watchidle", PipeTransport(droppingCenter(batches, finishes)))
+
+    client, _ := watchClient(t, "watchidle")
+    ready := make(chan struct{})
+    close(ready)
+    if err := runWatch(t, client, ready); err != context.Canceled {
+        t.Errorf("watch returned %v, want %v", err, context.Canceled)
+    }
+
+    select {
+    case <-finishes:
+    case <-time.After(time.Second):
+        t.Error("finish not sent with no files left")
+    }
+}
+
+func TestUnfinishedFiles(t *testing.T) {
+    client, dir := watchClient(t, "tcp")
+    pending := map[string]time.Time{
+        filepath.Join(dir, "a.csv"):    {},
+        filepath.Join(dir, "gone.csv"): {},
+    }
+    if err := os.WriteFile(filepath.Join(dir, "a.csv"), nil, 0644); err != nil {
+        t.Fatal(err)
+    }
+    progress := client.watchProgressPath("abc")
+    if err := os.WriteFile(progress, nil, 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    unfinished, err := client.unfinishedFiles(pending)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []string{progress, filepath.Join(dir, "a.csv")}
+    if len(unfinished) != 2 || unfinished[0] != want[0] || unfinished[1] != want[1] {
+        t.Errorf("got %v, want %v", unfinished, want)
+    }
+}
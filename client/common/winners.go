@@ -0,0 +1,182 @@
+package common
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+)
+
+// Formatos soportados para exportar los ganadores
+const (
+    WINNERS_CSV    = "csv"
+    WINNERS_JSON   = "json"
+    WINNERS_NDJSON = "ndjson"
+)
+
+// Winner es un documento ganador junto con la apuesta ganadora de la
+//  agencia que le corresponde. Si el documento no tiene una apuesta con
+//  el numero ganador en el archivo los campos de la apuesta quedan vacios
+type Winner struct {
+    Agency    string `json:"agency"`
+    Document  string `json:"document"`
+    Name      string `json:"name"`
+    Surname   string `json:"surname"`
+    BirthDate string `json:"birthdate"`
+    Number    string `json:"number"`
+}
+
+// Verifica que format sea uno de los formatos de exportacion soportados
+func ValidateWinnersFormat(format string) error {
+    switch format {
+    case WINNERS_CSV, WINNERS_JSON, WINNERS_NDJSON:
+        return nil
+    }
+    return fmt.Errorf("unknown winners format %q (expected %v, %v or %v)",
+        format, WINNERS_CSV, WINNERS_JSON, WINNERS_NDJSON)
+}
+
+// Busca en el archivo de apuestas la apuesta ganadora de cada documento.
+// Un documento puede tener varias apuestas: solo se considera la que tiene
+//  el numero ganador (ver isWinningBet). Si el servidor informa el mismo
+//  documento varias veces, a cada aparicion le corresponde la siguiente
+//  apuesta ganadora del archivo.
+// Los ganadores se devuelven en el mismo orden en que los envio el servidor
+func (c *Client) joinWinners(documents []string) ([]Winner, error) {
+    bets := make(map[string][]Bet, len(documents))
+    for _, document := range documents {
+        bets[document] = nil
+    }
+
+    err := scanBets(c.config.BetsFile, func(bet Bet) {
+        if _, ok := bets[bet.Document]; ok && c.isWinningBet(bet) {
+            bets[bet.Document] = append(bets[bet.Document], bet)
+        }
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    winners := make([]Winner, len(documents))
+    for i, document := range documents {
+        bet := Bet{}
+        if matches := bets[document]; len(matches) > 0 {
+            bet = matches[0]
+            if len(matches) > 1 {
+                bets[document] = matches[1:]
+            }
+        }
+        winners[i] = Winner{
+            Agency:    c.config.ID,
+            Document:  document,
+            Name:      bet.Name,
+            Surname:   bet.Surname,
+            BirthDate: bet.BirthDate,
+            Number:    bet.Number,
+        }
+    }
+    return winners, nil
+}
+
+// Recorre todas las apuestas del archivo ubicado en path
+func scanBets(path string, visit func(Bet)) error {
+    shards, err := splitShards(path, 1)
+    if err != nil {
+        return err
+    }
+
+    reader, err := openBetReader(path, shards[0])
+    if err != nil {
+        return err
+    }
+    defer reader.Close()
+
+    for {
+        bet, err := reader.Read()
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        visit(bet)
+    }
+}
+
+// Escribe los ganadores en WinnersOutput ("-" es stdout) con el formato
+//  WinnersFormat. Si no hay un archivo de salida configurado no hace nada
+func (c *Client) ExportWinners(documents []string) error {
+    if c.config.WinnersOutput == "" {
+        return nil
+    }
+
+    format := c.config.WinnersFormat
+    if format == "" {
+        format = WINNERS_CSV
+    }
+    if err := ValidateWinnersFormat(format); err != nil {
+        return err
+    }
+
+    winners, err := c.joinWinners(documents)
+    if err != nil {
+        return err
+    }
+
+    if c.config.WinnersOutput == "-" {
+        return writeWinners(os.Stdout, format, winners)
+    }
+
+    // Se escribe en un archivo temporal para no dejar una salida a medias
+    tmp := c.config.WinnersOutput + ".tmp"
+    file, err := os.Create(tmp)
+    if err != nil {
+        return err
+    }
+
+    err = writeWinners(file, format, winners)
+    if closeErr := file.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(tmp)
+        return err
+    }
+    return os.Rename(tmp, c.config.WinnersOutput)
+}
+
+// Escribe los ganadores en w con el formato dado
+func writeWinners(w io.Writer, format string, winners []Winner) error {
+    switch format {
+    case WINNERS_JSON:
+        encoder := json.NewEncoder(w)
+        encoder.SetIndent("", "  ")
+        return encoder.Encode(winners)
+
+    case WINNERS_NDJSON:
+        encoder := json.NewEncoder(w)
+        for _, winner := range winners {
+            if err := encoder.Encode(winner); err != nil {
+                return err
+            }
+        }
+        return nil
+
+    default:
+        writer := csv.NewWriter(w)
+        writer.Write([]string{"agency", "document", "name", "surname", "birthdate", "number"})
+        for _, winner := range winners {
+            writer.Write([]string{
+                winner.Agency,
+                winner.Document,
+                winner.Name,
+                winner.Surname,
+                winner.BirthDate,
+                winner.Number,
+            })
+        }
+        writer.Flush()
+        return writer.Error()
+    }
+}
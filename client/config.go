@@ -0,0 +1,412 @@
+package main
+
+import (
+  "fmt"
+  "net"
+  "os"
+  "path/filepath"
+  "regexp"
+  "strconv"
+  "strings"
+  "time"
+
+  "github.com/sirupsen/logrus"
+  "github.com/spf13/viper"
+
+  "github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+)
+
+// Config Typed configuration of the client. It is decoded with mapstructure
+// from every source known by viper (flags, env variables, config file and
+// defaults), so each key ends up in a field of the matching type
+type Config struct {
+  ID      string         `mapstructure:"id"`
+  Server  ServerConfig   `mapstructure:"server"`
+  Loop    LoopConfig     `mapstructure:"loop"`
+  Poll    PollConfig     `mapstructure:"poll"`
+  Log     LogConfig      `mapstructure:"log"`
+  Bets    BetsConfig     `mapstructure:"bets"`
+  DryRun  DryRunConfig   `mapstructure:"dry_run"`
+  Winners WinnersConfig  `mapstructure:"winners"`
+  Metrics MetricsConfig  `mapstructure:"metrics"`
+  Watch   WatchDirConfig `mapstructure:"watch"`
+  Spool   SpoolConfig    `mapstructure:"spool"`
+}
+
+// ServerConfig Addresses of the lottery center, in order of preference: the
+// first one is the primary and the rest are standbys. A failed address is
+// tried after the healthy ones until Cooldown expires. DialTimeout and
+// IOTimeout bound the wait for a connection and for each send or reply
+// (0 waits forever)
+type ServerConfig struct {
+  Address     []string      `mapstructure:"address"`
+  Cooldown    time.Duration `mapstructure:"cooldown"`
+  DialTimeout time.Duration `mapstructure:"dial_timeout"`
+  IOTimeout   time.Duration `mapstructure:"io_timeout"`
+}
+
+// LoopConfig Periodic upload of the bets file: it is scanned every Period
+// for new bets until Lapse expires. Lapse 0 uploads the file once
+type LoopConfig struct {
+  Period time.Duration `mapstructure:"period"`
+  Lapse  time.Duration `mapstructure:"lapse"`
+}
+
+// PollConfig Exponential backoff between winner polls. BackoffMax 0 means
+// the wait is not limited
+type PollConfig struct {
+  BackoffInitial time.Duration `mapstructure:"backoff_initial"`
+  BackoffMax     time.Duration `mapstructure:"backoff_max"`
+}
+
+// LogConfig Logger configuration
+type LogConfig struct {
+  Level  string `mapstructure:"level"`
+  Format string `mapstructure:"format"`
+  Redact string `mapstructure:"redact"`
+}
+
+// BetsConfig Bets file and how it is uploaded
+type BetsConfig struct {
+  File          string          `mapstructure:"file"`
+  BatchSize     uint            `mapstructure:"batch_size"`
+  BatchMaxBytes uint            `mapstructure:"batch_max_bytes"`
+  Parallel      uint            `mapstructure:"parallel"`
+  ProgressFile  string          `mapstructure:"progress_file"`
+  Adaptive      AdaptiveConfig  `mapstructure:"adaptive"`
+  RateLimit     RateLimitConfig `mapstructure:"rate_limit"`
+}
+
+// AdaptiveConfig Adaptive batch size mode
+type AdaptiveConfig struct {
+  Enabled       bool          `mapstructure:"enabled"`
+  TargetLatency time.Duration `mapstructure:"target_latency"`
+  MinBatchSize  uint          `mapstructure:"min_batch_size"`
+  MaxBatchSize  uint          `mapstructure:"max_batch_size"`
+  Increase      uint          `mapstructure:"increase"`
+  Decrease      float64       `mapstructure:"decrease"`
+}
+
+// RateLimitConfig Upload rate limit of the agency, shared by all its
+// connections. A limit of 0 is not applied, and a burst of 0 is one second
+// of upload
+type RateLimitConfig struct {
+  BetsPerSecond  uint `mapstructure:"bets_per_second"`
+  BytesPerSecond uint `mapstructure:"bytes_per_second"`
+  BurstBets      uint `mapstructure:"burst_bets"`
+  BurstBytes     uint `mapstructure:"burst_bytes"`
+}
+
+// DryRunConfig Dry run mode
+type DryRunConfig struct {
+  Enabled bool   `mapstructure:"enabled"`
+  Output  string `mapstructure:"output"`
+}
+
+// WinnersConfig Export and verification of the winners
+type WinnersConfig struct {
+  Output        string `mapstructure:"output"`
+  Format        string `mapstructure:"format"`
+  Verify        bool   `mapstructure:"verify"`
+  WinningNumber int    `mapstructure:"winning_number"`
+}
+
+// MetricsConfig Prometheus metrics listener
+type MetricsConfig struct {
+  Address string `mapstructure:"address"`
+}
+
+// WatchDirConfig Directory watched by the watch command
+type WatchDirConfig struct {
+  Dir          string        `mapstructure:"dir"`
+  Pattern      string        `mapstructure:"pattern"`
+  Settle       time.Duration `mapstructure:"settle"`
+  Journal      string        `mapstructure:"journal"`
+  FinishOnExit bool          `mapstructure:"finish_on_exit"`
+}
+
+// SpoolConfig Local spool where the bets are stored until they are forwarded
+// to the lottery center. An empty Dir disables it
+type SpoolConfig struct {
+  Dir   string        `mapstructure:"dir"`
+  Retry time.Duration `mapstructure:"retry"`
+}
+
+// ConfigError Every problem found in the configuration
+type ConfigError struct {
+  Problems []string
+}
+
+func (e *ConfigError) Error() string {
+  return fmt.Sprintf("%d configuration problem(s):\n  %s", len(e.Problems), strings.Join(e.Problems, "\n  "))
+}
+
+// Key quoted by mapstructure in its decoding errors
+var decodeErrorKey = regexp.MustCompile(`'([^']+)'`)
+
+// LoadConfig Decodes the configuration loaded by viper and validates it for
+// the given command. If some key cannot be decoded or has an invalid value, a
+// *ConfigError with all the problems found is returned
+func LoadConfig(v *viper.Viper, command string) (*Config, error) {
+  config := &Config{}
+  problems := []string{}
+
+  // mapstructure keeps decoding the remaining keys when one fails and
+  // reports all of them, so the decoding errors are just more problems.
+  // The keys that failed are not validated again
+  failed := map[string]bool{}
+  if err := v.Unmarshal(config); err != nil {
+    for _, e := range decodeErrors(err) {
+      problems = append(problems, e)
+      if match := decodeErrorKey.FindStringSubmatch(e); match != nil {
+        failed[strings.ToLower(match[1])] = true
+      }
+    }
+  }
+
+  for _, problem := range config.Validate(command) {
+    key := strings.SplitN(problem, ":", 2)[0]
+    if !failed[key] {
+      problems = append(problems, problem)
+    }
+  }
+
+  if len(problems) > 0 {
+    return nil, &ConfigError{Problems: problems}
+  }
+  return config, nil
+}
+
+// Returns the individual errors of a mapstructure decoding error
+func decodeErrors(err error) []string {
+  if wrapped, ok := err.(interface{ WrappedErrors() []error }); ok {
+    errs := []string{}
+    for _, e := range wrapped.WrappedErrors() {
+      errs = append(errs, e.Error())
+    }
+    return errs
+  }
+  return []string{err.Error()}
+}
+
+// Validate Returns every problem of the configuration for the given command,
+// each one prefixed by the key it refers to. An empty list means the
+// configuration is valid
+func (c *Config) Validate(command string) []string {
+  problems := []string{}
+  add := func(key string, format string, args ...interface{}) {
+    problems = append(problems, key+": "+fmt.Sprintf(format, args...))
+  }
+
+  if c.ID == "" {
+    add("id", "missing agency number (set id or CLI_ID)")
+  } else if id, err := strconv.ParseInt(c.ID, 10, 32); err != nil || id <= 0 {
+    add("id", "agency number must be a positive integer, got %q", c.ID)
+  }
+
+  if len(c.Server.Address) == 0 {
+    add("server.address", "missing lottery center address (set server.address or CLI_SERVER_ADDRESS)")
+  }
+  for _, address := range c.Server.Address {
+    if err := validateServerAddress(address); err != nil {
+      add("server.address", "%v", err)
+    }
+  }
+  if c.Server.Cooldown < 0 {
+    add("server.cooldown", "must not be negative, got %v", c.Server.Cooldown)
+  }
+  if c.Server.DialTimeout < 0 {
+    add("server.dial_timeout", "must not be negative, got %v", c.Server.DialTimeout)
+  }
+  if c.Server.IOTimeout < 0 {
+    add("server.io_timeout", "must not be negative, got %v", c.Server.IOTimeout)
+  }
+
+  if c.Loop.Period < 0 {
+    add("loop.period", "must not be negative, got %v", c.Loop.Period)
+  }
+  if c.Loop.Lapse < 0 {
+    add("loop.lapse", "must not be negative, got %v", c.Loop.Lapse)
+  } else if c.Loop.Lapse > 0 && c.Loop.Period <= 0 {
+    add("loop.period", "must be greater than 0 when loop.lapse is set, got %v", c.Loop.Period)
+  }
+
+  if c.Poll.BackoffInitial <= 0 {
+    add("poll.backoff_initial", "must be greater than 0, got %v", c.Poll.BackoffInitial)
+  }
+  if c.Poll.BackoffMax < 0 {
+    add("poll.backoff_max", "must not be negative, got %v", c.Poll.BackoffMax)
+  } else if c.Poll.BackoffMax > 0 && c.Poll.BackoffMax < c.Poll.BackoffInitial {
+    add("poll.backoff_max", "must not be lower than backoff_initial (%v), got %v", c.Poll.BackoffInitial, c.Poll.BackoffMax)
+  }
+
+  if _, err := logrus.ParseLevel(c.Log.Level); err != nil {
+    add("log.level", "%v", err)
+  }
+  if _, err := common.NewLogFormatter(c.Log.Format); err != nil {
+    add("log.format", "%v", err)
+  }
+  if _, err := common.ParseRedactPolicy(c.Log.Redact); err != nil {
+    add("log.redact", "%v", err)
+  }
+
+  // The watch command takes its bets files from watch.dir
+  if command == "watch" {
+    if c.Watch.Dir == "" {
+      add("watch.dir", "missing watched directory (set watch.dir or CLI_WATCH_DIR)")
+    } else if info, err := os.Stat(c.Watch.Dir); err != nil {
+      add("watch.dir", "%v", err)
+    } else if !info.IsDir() {
+      add("watch.dir", "%q is not a directory", c.Watch.Dir)
+    }
+    if _, err := filepath.Match(c.Watch.Pattern, ""); err != nil || c.Watch.Pattern == "" {
+      add("watch.pattern", "invalid file pattern %q", c.Watch.Pattern)
+    }
+    if c.Watch.Settle <= 0 {
+      add("watch.settle", "must be greater than 0, got %v", c.Watch.Settle)
+    }
+    if c.DryRun.Enabled {
+      add("dry_run.enabled", "not supported by the watch command")
+    }
+  } else if c.Bets.File == "" {
+    add("bets.file", "missing bets file (set bets.file or CLI_BETS_FILE)")
+  } else if info, err := os.Stat(c.Bets.File); err != nil {
+    add("bets.file", "%v", err)
+  } else if info.IsDir() {
+    add("bets.file", "%q is a directory", c.Bets.File)
+  }
+  if c.Bets.BatchSize == 0 {
+    add("bets.batch_size", "must be greater than 0 (set bets.batch_size or CLI_BETS_BATCH_SIZE)")
+  }
+  if c.Bets.BatchMaxBytes > 0 && c.Bets.BatchMaxBytes <= common.TL_LENGTH {
+    add("bets.batch_max_bytes", "must be greater than %d bytes, the batch header", common.TL_LENGTH)
+  }
+  if c.Bets.Parallel == 0 {
+    add("bets.parallel", "must be at least 1")
+  }
+
+  if adaptive := c.Bets.Adaptive; adaptive.Enabled {
+    if adaptive.TargetLatency <= 0 {
+      add("bets.adaptive.target_latency", "must be greater than 0, got %v", adaptive.TargetLatency)
+    }
+    if adaptive.MinBatchSize == 0 {
+      add("bets.adaptive.min_batch_size", "must be greater than 0")
+    }
+    if adaptive.MaxBatchSize < adaptive.MinBatchSize {
+      add("bets.adaptive.max_batch_size", "must not be lower than min_batch_size (%d), got %d", adaptive.MinBatchSize, adaptive.MaxBatchSize)
+    }
+    if adaptive.Decrease <= 0 || adaptive.Decrease >= 1 {
+      add("bets.adaptive.decrease", "must be between 0 and 1 (exclusive), got %v", adaptive.Decrease)
+    }
+  }
+
+  if c.Winners.Output != "" {
+    if err := common.ValidateWinnersFormat(c.Winners.Format); err != nil {
+      add("winners.format", "%v", err)
+    }
+  }
+
+  if c.Spool.Retry <= 0 {
+    add("spool.retry", "must be greater than 0, got %v", c.Spool.Retry)
+  }
+  if c.Spool.Dir != "" {
+    if info, err := os.Stat(c.Spool.Dir); err == nil && !info.IsDir() {
+      add("spool.dir", "%q is not a directory", c.Spool.Dir)
+    }
+  }
+
+  if c.Metrics.Address != "" {
+    if err := validateAddress(c.Metrics.Address); err != nil {
+      add("metrics.address", "%v", err)
+    }
+  }
+
+  return problems
+}
+
+// Checks that address has a registered transport scheme (tcp:// when it has
+// none) and, for tcp, the host:port form with a valid port
+func validateServerAddress(address string) error {
+  scheme, target, err := common.ParseServerAddress(address)
+  if err != nil {
+    return err
+  }
+  if scheme == common.TRANSPORT_TCP {
+    return validateAddress(target)
+  }
+  return nil
+}
+
+// Checks that address has the host:port form with a valid port
+func validateAddress(address string) error {
+  _, port, err := net.SplitHostPort(address)
+  if err != nil {
+    return fmt.Errorf("expected host:port, got %q", address)
+  }
+  if number, err := strconv.ParseUint(port, 10, 16); err != nil || number == 0 {
+    return fmt.Errorf("invalid port %q in %q", port, address)
+  }
+  return nil
+}
+
+// ProgressFile Returns the file where the upload progress is saved. By default
+// it is the bets file followed by the .progress extension
+func (c *Config) ProgressFile() string {
+  if c.Bets.ProgressFile != "" {
+    return c.Bets.ProgressFile
+  }
+  return c.Bets.File + ".progress"
+}
+
+// ClientConfig Returns the configuration used by common.Client
+func (c *Config) ClientConfig() common.ClientConfig {
+  return common.ClientConfig{
+    ServerAddresses: c.Server.Address,
+    ServerCooldown:  c.Server.Cooldown,
+    ServerTimeouts:  common.ServerTimeouts{
+      Dial: c.Server.DialTimeout,
+      IO:   c.Server.IOTimeout,
+    },
+    ID:            c.ID,
+    BetsFile:      c.Bets.File,
+    BatchSize:     c.Bets.BatchSize,
+    BatchMaxBytes: c.Bets.BatchMaxBytes,
+    Parallel:      c.Bets.Parallel,
+    ProgressFile:  c.ProgressFile(),
+    DryRun:        c.DryRun.Enabled,
+    DryRunOutput:  c.DryRun.Output,
+    WinnersOutput: c.Winners.Output,
+    WinnersFormat: c.Winners.Format,
+    VerifyWinners: c.Winners.Verify,
+    WinningNumber: c.Winners.WinningNumber,
+    PollBackoff:    c.Poll.BackoffInitial,
+    PollBackoffMax: c.Poll.BackoffMax,
+    LoopPeriod:     c.Loop.Period,
+    LoopLapse:      c.Loop.Lapse,
+    RateLimit: common.RateLimitConfig{
+      BetsPerSecond:  c.Bets.RateLimit.BetsPerSecond,
+      BytesPerSecond: c.Bets.RateLimit.BytesPerSecond,
+      BurstBets:      c.Bets.RateLimit.BurstBets,
+      BurstBytes:     c.Bets.RateLimit.BurstBytes,
+    },
+    Spool: common.SpoolConfig{
+      Dir:   c.Spool.Dir,
+      Retry: c.Spool.Retry,
+    },
+    Watch: common.WatchConfig{
+      Dir:          c.Watch.Dir,
+      Pattern:      c.Watch.Pattern,
+      Settle:       c.Watch.Settle,
+      Journal:      c.Watch.Journal,
+      FinishOnExit: c.Watch.FinishOnExit,
+    },
+    Adaptive: common.AdaptiveConfig{
+      Enabled:       c.Bets.Adaptive.Enabled,
+      TargetLatency: c.Bets.Adaptive.TargetLatency,
+      MinBatchSize:  c.Bets.Adaptive.MinBatchSize,
+      MaxBatchSize:  c.Bets.Adaptive.MaxBatchSize,
+      Increase:      c.Bets.Adaptive.Increase,
+      Decrease:      c.Bets.Adaptive.Decrease,
+    },
+  }
+}
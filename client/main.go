@@ -1,24 +1,31 @@
 package main
 
 import (
+  "context"
   "fmt"
+  "net/http"
+  "os"
+  "os/signal"
   "strings"
-  "time"
+  "sync"
+  "syscall"
 
   "github.com/pkg/errors"
   "github.com/sirupsen/logrus"
   log "github.com/sirupsen/logrus"
+  "github.com/spf13/pflag"
   "github.com/spf13/viper"
 
   "github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
 )
 
 // InitConfig Function that uses viper library to parse configuration parameters.
-// Viper is configured to read variables from both environment variables and the
-// config file ./config.yaml. Environment variables takes precedence over parameters
-// defined in the configuration file. If some of the variables cannot be parsed,
-// an error is returned
-func InitConfig() (*viper.Viper, error) {
+// Viper is configured to read variables from command line flags, environment
+// variables and the config file (./config.yaml unless --config is given). Flags
+// take precedence over environment variables, and environment variables over
+// parameters defined in the configuration file. The values are decoded and
+// validated later by LoadConfig
+func InitConfig(flags *pflag.FlagSet) (*viper.Viper, error) {
   v := viper.New()
 
   // Configure viper to read env variables with the CLI_ prefix
@@ -31,84 +38,269 @@ func InitConfig() (*viper.Viper, error) {
 
   // Add env variables supported
   v.BindEnv("id")
-  v.BindEnv("server", "address")
-  v.BindEnv("loop", "period")
-  v.BindEnv("loop", "lapse")
-  v.BindEnv("log", "level")
+  v.BindEnv("server.address")
+  v.BindEnv("server.cooldown")
+  v.BindEnv("server.dial_timeout")
+  v.BindEnv("server.io_timeout")
+  v.BindEnv("loop.period")
+  v.BindEnv("loop.lapse")
+  v.BindEnv("poll.backoff_initial")
+  v.BindEnv("poll.backoff_max")
+  v.BindEnv("log.level")
+  v.BindEnv("log.format")
+  v.BindEnv("log.redact")
 
-  v.BindEnv("bets", "file")
-  v.BindEnv("bets", "batch_size")
+  v.BindEnv("bets.file")
+  v.BindEnv("bets.batch_size")
+  v.BindEnv("bets.batch_max_bytes")
+  v.BindEnv("bets.parallel")
+  v.BindEnv("bets.progress_file")
+  v.BindEnv("dry_run.enabled")
+  v.BindEnv("dry_run.output")
+  v.BindEnv("winners.output")
+  v.BindEnv("winners.format")
+  v.BindEnv("winners.verify")
+  v.BindEnv("winners.winning_number")
+  v.BindEnv("metrics.address")
+  v.BindEnv("spool.dir")
+  v.BindEnv("spool.retry")
+  v.BindEnv("watch.dir")
+  v.BindEnv("watch.pattern")
+  v.BindEnv("watch.settle")
+  v.BindEnv("watch.journal")
+  v.BindEnv("watch.finish_on_exit")
+  v.BindEnv("bets.adaptive.enabled")
+  v.BindEnv("bets.adaptive.target_latency")
+  v.BindEnv("bets.adaptive.min_batch_size")
+  v.BindEnv("bets.adaptive.max_batch_size")
+  v.BindEnv("bets.adaptive.increase")
+  v.BindEnv("bets.adaptive.decrease")
+  v.BindEnv("bets.rate_limit.bets_per_second")
+  v.BindEnv("bets.rate_limit.bytes_per_second")
+  v.BindEnv("bets.rate_limit.burst_bets")
+  v.BindEnv("bets.rate_limit.burst_bytes")
+
+  v.SetDefault("log.level", "info")
+  v.SetDefault("poll.backoff_initial", "1s")
+  v.SetDefault("poll.backoff_max", "0s")
+  v.SetDefault("log.format", common.LOG_TEXT)
+  v.SetDefault("log.redact", "all")
+  v.SetDefault("bets.parallel", 1)
+  v.SetDefault("dry_run.output", "-")
+  v.SetDefault("winners.format", common.WINNERS_CSV)
+  v.SetDefault("winners.verify", true)
+  v.SetDefault("winners.winning_number", common.LOTTERY_WINNER_NUMBER)
+  v.SetDefault("server.cooldown", common.DEFAULT_SERVER_COOLDOWN.String())
+  v.SetDefault("server.dial_timeout", common.DEFAULT_DIAL_TIMEOUT.String())
+  v.SetDefault("server.io_timeout", common.DEFAULT_IO_TIMEOUT.String())
+  v.SetDefault("spool.retry", common.DEFAULT_SPOOL_RETRY.String())
+  v.SetDefault("watch.pattern", "*.csv")
+  v.SetDefault("watch.settle", "2s")
+
+  // Defaults of the adaptive batch size mode
+  v.SetDefault("bets.adaptive.target_latency", "250ms")
+  v.SetDefault("bets.adaptive.min_batch_size", 1)
+  v.SetDefault("bets.adaptive.max_batch_size", 1000)
+  v.SetDefault("bets.adaptive.increase", 10)
+  v.SetDefault("bets.adaptive.decrease", 0.5)
+
+  // Flags only override the configuration when they are explicitly set
+  for flag, key := range ConfigFlags {
+    if err := v.BindPFlag(key, flags.Lookup(flag)); err != nil {
+      return nil, errors.Wrapf(err, "Could not bind flag --%s.", flag)
+    }
+  }
 
   // Try to read configuration from config file. If config file
   // does not exists then ReadInConfig will fail but configuration
   // can be loaded from the environment variables so we shouldn't
   // return an error in that case
-  v.SetConfigFile("./config.yaml")
+  configFile, _ := flags.GetString("config")
+  v.SetConfigFile(configFile)
   if err := v.ReadInConfig(); err != nil {
     fmt.Printf("Configuration could not be read from config file. Using env variables instead")
   }
 
-  // Parse time.Duration variables and return an error if those variables cannot be parsed
-  if _, err := time.ParseDuration(v.GetString("loop.lapse")); err != nil {
-    return nil, errors.Wrapf(err, "Could not parse CLI_LOOP_LAPSE env var as time.Duration.")
-  }
-
-  if _, err := time.ParseDuration(v.GetString("loop.period")); err != nil {
-    return nil, errors.Wrapf(err, "Could not parse CLI_LOOP_PERIOD env var as time.Duration.")
-  }
-
   return v, nil
 }
 
-// InitLogger Receives the log level to be set in logrus as a string. This method
-// parses the string and set the level to the logger. If the level string is not
-// valid an error is returned
-func InitLogger(logLevel string) error {
+// InitLogger Receives the log level, the log format (text or json) and the
+// redact policy to be set in logrus as strings. This method parses the strings
+// and set the level, the formatter and the redact hook to the logger. If any
+// of them is not valid an error is returned
+func InitLogger(logLevel string, logFormat string, redact string) error {
   level, err := logrus.ParseLevel(logLevel)
   if err != nil {
     return err
   }
 
-    customFormatter := &logrus.TextFormatter{
-      TimestampFormat: "2006-01-02 15:04:05",
-      FullTimestamp: false,
-    }
-    logrus.SetFormatter(customFormatter)
+  formatter, err := common.NewLogFormatter(logFormat)
+  if err != nil {
+    return err
+  }
+
+  policy, err := common.ParseRedactPolicy(redact)
+  if err != nil {
+    return err
+  }
+
+  logrus.SetFormatter(formatter)
   logrus.SetLevel(level)
+  logrus.AddHook(&common.RedactHook{Policy: policy})
   return nil
 }
 
 // PrintConfig Print all the configuration parameters of the program.
 // For debugging purposes only
-func PrintConfig(v *viper.Viper) {
-  logrus.Infof("action: config | result: success | client_id: %s | server_address: %s | log_level: %s | file: %s | batch_size: %v",
-    v.GetString("id"),
-    v.GetString("server.address"),
-    v.GetString("log.level"),
-    v.GetString("bets.file"),
-    v.GetUint("bets.batch_size"),
-  )
+func PrintConfig(config *Config) {
+  common.LogAction("config", "success").WithFields(logrus.Fields{
+    "client_id":       config.ID,
+    "server_address":  strings.Join(config.Server.Address, ","),
+    "loop_lapse":      config.Loop.Lapse.String(),
+    "loop_period":     config.Loop.Period.String(),
+    "log_level":       config.Log.Level,
+    "file":            config.Bets.File,
+    "batch_size":      config.Bets.BatchSize,
+    "batch_max_bytes": config.Bets.BatchMaxBytes,
+    "adaptive":        config.Bets.Adaptive.Enabled,
+    "parallel":        config.Bets.Parallel,
+  }).Info()
+}
+
+// ServeMetrics Serves the client metrics in the Prometheus text format at
+// http://<address>/metrics. The listener runs in background for the whole
+// life of the process; if it cannot be started the error is logged and the
+// client keeps running without metrics
+func ServeMetrics(address string, metrics *common.Metrics) {
+  mux := http.NewServeMux()
+  mux.Handle("/metrics", metrics)
+
+  go func() {
+    common.LogAction("serve_metrics", "in_progress").WithField("address", address).Info()
+    if err := http.ListenAndServe(address, mux); err != nil {
+      common.LogAction("serve_metrics", "fail").WithField("address", address).WithError(err).Error()
+    }
+  }()
+}
+
+// Exit status of the client:
+//  * 0: the command succeeded
+//  * 1: the command failed (see the fatal log entry), or validate found
+//      invalid bets
+//  * 2: invalid command, flags or configuration (every problem of the
+//      configuration is logged before exiting)
+//  * 3: the winners received do not match the agency bets file (see the
+//      verify_winners log entries)
+//  * 130 / 143: interrupted by SIGINT / SIGTERM. The in-flight batch was
+//      finished or aborted, the progress saved and the socket closed, so
+//      running the client again resumes the upload
+const (
+  ExitSuccess  = 0
+  ExitFailure  = 1
+  ExitUsage    = 2
+  ExitMismatch = 3
+)
+
+var (
+  signalMutex sync.Mutex
+  lastSignal  os.Signal
+)
+
+// HandleSignals Returns a context that is cancelled when the process receives
+// SIGTERM or SIGINT. A second signal terminates the process immediately
+func HandleSignals() context.Context {
+  ctx, cancel := context.WithCancel(context.Background())
+  signals := make(chan os.Signal, 2)
+  signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+  go func() {
+    received := <-signals
+    common.LogAction("signal_received", "success").WithField("signal", received.String()).Info()
+    signalMutex.Lock()
+    lastSignal = received
+    signalMutex.Unlock()
+    cancel()
+
+    forced := <-signals
+    common.LogAction("signal_received", "success").WithFields(log.Fields{
+      "signal": forced.String(),
+      "info":   "forcing exit",
+    }).Warn()
+    os.Exit(ExitStatus(forced))
+  }()
+
+  return ctx
+}
+
+// ReceivedSignal Returns the signal that cancelled the context returned by
+// HandleSignals, or nil if no signal was received
+func ReceivedSignal() os.Signal {
+  signalMutex.Lock()
+  defer signalMutex.Unlock()
+  return lastSignal
 }
+
+// ExitStatus Returns the conventional exit status of a process terminated by sig
+func ExitStatus(sig os.Signal) int {
+  if s, ok := sig.(syscall.Signal); ok {
+    return 128 + int(s)
+  }
+  return ExitFailure
+}
+
 func main() {
-  v, err := InitConfig()
+  command, args, err := ParseCommand(os.Args[1:])
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "%s\n", err)
+    NewFlagSet("client").Usage()
+    os.Exit(ExitUsage)
+  }
+
+  flags := NewFlagSet(command.Name)
+  if err := flags.Parse(args); err != nil {
+    if err == pflag.ErrHelp {
+      os.Exit(ExitSuccess)
+    }
+    os.Exit(ExitUsage)
+  }
+
+  if command.Name == "version" {
+    fmt.Println(Version)
+    os.Exit(ExitSuccess)
+  }
+
+  v, err := InitConfig(flags)
   if err != nil {
     log.Fatalf("%s", err)
   }
 
-  if err := InitLogger(v.GetString("log.level")); err != nil {
+  config, err := LoadConfig(v, command.Name)
+  if err != nil {
+    if configErr, ok := err.(*ConfigError); ok {
+      for _, problem := range configErr.Problems {
+        common.LogAction("config", "fail").WithField("error", problem).Error()
+      }
+    } else {
+      common.LogAction("config", "fail").WithError(err).Error()
+    }
+    os.Exit(ExitUsage)
+  }
+
+  if err := InitLogger(config.Log.Level, config.Log.Format, config.Log.Redact); err != nil {
     log.Fatalf("%s", err)
   }
 
   // Print program config with debugging purposes
-  PrintConfig(v)
+  PrintConfig(config)
 
-  clientConfig := common.ClientConfig{
-    ServerAddress: v.GetString("server.address"),
-    ID:            v.GetString("id"),
-    BetsFile:      v.GetString("bets.file"),
-    BatchSize:     v.GetUint("bets.batch_size"),
-  }
+  ctx := HandleSignals()
 
-  client := common.NewClient(clientConfig)
-  client.Run()
+  client := common.NewClient(config.ClientConfig())
+  if config.Metrics.Address != "" {
+    ServeMetrics(config.Metrics.Address, client.Metrics())
+  }
+  if _, err := os.Stat(v.ConfigFileUsed()); err == nil {
+    WatchConfig(v, client, config, command.Name)
+  }
+  os.Exit(command.Run(ctx, client))
 }
@@ -0,0 +1,127 @@
+package main
+
+import (
+  "reflect"
+  "strings"
+  "sync"
+
+  "github.com/fsnotify/fsnotify"
+  "github.com/sirupsen/logrus"
+  log "github.com/sirupsen/logrus"
+  "github.com/spf13/viper"
+
+  "github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+)
+
+// ReloadableKeys Configuration keys that can change while the client runs.
+// A change to any other key (for example id or server.address) is rejected
+// with a warning and the client keeps the value it started with
+var ReloadableKeys = []string{
+  "log.level",
+  "poll.backoff_initial",
+  "poll.backoff_max",
+  "bets.batch_size",
+  "bets.batch_max_bytes",
+  "bets.adaptive.target_latency",
+  "bets.adaptive.min_batch_size",
+  "bets.adaptive.max_batch_size",
+  "bets.adaptive.increase",
+  "bets.adaptive.decrease",
+  "bets.rate_limit.bets_per_second",
+  "bets.rate_limit.bytes_per_second",
+  "bets.rate_limit.burst_bets",
+  "bets.rate_limit.burst_bytes",
+}
+
+// WatchConfig Watches the config file and applies the reloadable keys every
+// time it changes. The new configuration is validated as a whole first: if it
+// has problems they are logged and nothing is applied. Otherwise only the
+// reloadable keys are applied, and every other changed key is rejected. The
+// configuration is validated for the command the client is running
+func WatchConfig(v *viper.Viper, client *common.Client, initial *Config, command string) {
+  var mu sync.Mutex
+  current := *initial
+
+  v.OnConfigChange(func(event fsnotify.Event) {
+    mu.Lock()
+    defer mu.Unlock()
+
+    config, err := LoadConfig(v, command)
+    if err != nil {
+      if configErr, ok := err.(*ConfigError); ok {
+        for _, problem := range configErr.Problems {
+          common.LogAction("reload_config", "fail").WithFields(log.Fields{"file": event.Name, "error": problem}).Warn()
+        }
+      } else {
+        common.LogAction("reload_config", "fail").WithField("file", event.Name).WithError(err).Warn()
+      }
+      return
+    }
+
+    applied := []string{}
+    for _, key := range ChangedKeys(&current, config) {
+      if !isReloadable(key) {
+        common.LogAction("reload_config", "rejected").WithFields(log.Fields{
+          "key":    key,
+          "reason": "cannot change while running, restart the client",
+        }).Warn()
+        continue
+      }
+      applied = append(applied, key)
+    }
+    if len(applied) == 0 {
+      return
+    }
+
+    current = withReloadableKeys(current, config)
+    level, _ := logrus.ParseLevel(current.Log.Level)
+    logrus.SetLevel(level)
+    client.Reload(current.ClientConfig())
+    common.LogAction("reload_config", "success").WithField("keys", strings.Join(applied, ", ")).Info()
+  })
+  v.WatchConfig()
+}
+
+// Returns running with the reloadable keys taken from config
+func withReloadableKeys(running Config, config *Config) Config {
+  running.Log.Level = config.Log.Level
+  running.Poll = config.Poll
+  running.Bets.BatchSize = config.Bets.BatchSize
+  running.Bets.BatchMaxBytes = config.Bets.BatchMaxBytes
+  running.Bets.RateLimit = config.Bets.RateLimit
+
+  enabled := running.Bets.Adaptive.Enabled
+  running.Bets.Adaptive = config.Bets.Adaptive
+  running.Bets.Adaptive.Enabled = enabled
+  return running
+}
+
+func isReloadable(key string) bool {
+  for _, reloadable := range ReloadableKeys {
+    if key == reloadable {
+      return true
+    }
+  }
+  return false
+}
+
+// ChangedKeys Returns the keys (as named in the config file) whose values
+// differ between old and new
+func ChangedKeys(old *Config, new *Config) []string {
+  return changedKeys("", reflect.ValueOf(*old), reflect.ValueOf(*new))
+}
+
+func changedKeys(prefix string, old reflect.Value, new reflect.Value) []string {
+  keys := []string{}
+  for i := 0; i < old.NumField(); i++ {
+    key := prefix + old.Type().Field(i).Tag.Get("mapstructure")
+    if old.Field(i).Kind() == reflect.Struct {
+      keys = append(keys, changedKeys(key+".", old.Field(i), new.Field(i))...)
+      continue
+    }
+    if !reflect.DeepEqual(old.Field(i).Interface(), new.Field(i).Interface()) {
+      keys = append(keys, key)
+    }
+  }
+  return keys
+}
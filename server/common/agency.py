@@ -63,6 +63,10 @@ class Agency(threading.Thread):
                         force_to_wait(self.client_sock)
                         break # goodbye
 
+            except ConnectionError as e:
+                logging.info(f"action: connection_closed | result: success | error: {e}")
+                break
+
             except Exception as e:
                 logging.error(f"action: request_processed | result: fail | error: {e}")
                 break
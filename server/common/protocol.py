@@ -32,7 +32,7 @@ POLL_WINNERS_REQ = 3        # REQUEST de solicitud de ganadores
 def read_all(socket, bytes_to_read):
     """
     Lee del socket la cantidad exacta de bytes.
-    En caso de error levanta una excepcion 
+    En caso de error, o si el cliente cierra la conexion, levanta una excepcion 
     """
     data = b''
     while len(data) < bytes_to_read: 
@@ -41,6 +41,8 @@ def read_all(socket, bytes_to_read):
         except OSError as e:
             # logging
             raise e
+        if not new_data:
+            raise ConnectionError("connection closed by peer")
         data += new_data
     return data
 
@@ -1,5 +1,10 @@
 from common.utils import *
+from common.agency import Agency
+from common.counter import Counter
+from common.protocol import read_all, recv_req, BATCH_TYPE, BET_TYPE
 import os
+import socket
+import threading
 import unittest
 
 class TestUtils(unittest.TestCase):
@@ -54,6 +59,43 @@ class TestUtils(unittest.TestCase):
         self.assertEqual(b1.birthdate, b2.birthdate)
         self.assertEqual(b1.number, b2.number)
 
+class TestProtocol(unittest.TestCase):
+
+    def setUp(self):
+        self.server_sock, self.client_sock = socket.socketpair()
+
+    def tearDown(self):
+        self.server_sock.close()
+        self.client_sock.close()
+        if os.path.exists(STORAGE_FILEPATH):
+            os.remove(STORAGE_FILEPATH)
+
+    def test_read_all_with_peer_closed_mid_read_must_raise_connection_error(self):
+        self.client_sock.sendall(b'ab')
+        self.client_sock.close()
+        with self.assertRaises(ConnectionError):
+            read_all(self.server_sock, 4)
+
+    def test_recv_req_with_peer_closed_mid_frame_must_raise_connection_error(self):
+        # Batch de 2 apuestas cortado en medio de la primera
+        self.client_sock.sendall(BATCH_TYPE.encode() + (2).to_bytes(4, 'big') + BET_TYPE.encode() + (40).to_bytes(4, 'big') + b'A')
+        self.client_sock.close()
+        with self.assertRaises(ConnectionError):
+            recv_req(self.server_sock)
+
+    def test_agency_with_peer_closed_mid_frame_must_stop_without_storing_bets(self):
+        agency = Agency(self.server_sock, threading.Lock(), Counter(0), threading.Lock(), 1)
+        self.client_sock.sendall(BATCH_TYPE.encode() + (2).to_bytes(4, 'big'))
+        self.client_sock.close()
+
+        with self.assertLogs(level='INFO') as logs:
+            agency.start()
+            agency.join(timeout=5)
+
+        self.assertFalse(agency.is_alive())
+        self.assertIn('action: connection_closed | result: success', logs.output[-1])
+        self.assertFalse(os.path.exists(STORAGE_FILEPATH))
+
 if __name__ == '__main__':
     unittest.main()
 
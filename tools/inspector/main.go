@@ -0,0 +1,89 @@
+package main
+
+import (
+    "bufio"
+    "encoding/hex"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+
+    "github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+)
+
+// Inspector del protocolo TLV entre las agencias y la central.
+//
+// Lee una captura de bytes crudos (de un archivo o de stdin) y muestra
+//  los frames decodificados como un arbol: tag, largo y valor de cada campo.
+// Los frames mal formados o truncados se marcan con el offset donde se
+//  detecto el problema. Los datos personales se enmascaran segun -redact,
+//  tanto en el arbol como en el volcado hexadecimal.
+//
+// Uso:
+//  inspector [-hex] [-redact all] [archivo]
+func main() {
+    showHex := flag.Bool("hex", false, "print the raw bytes of every top-level frame")
+    redactPolicy := flag.String("redact", "all", "personal data masked in the output: all, none or a list of document, name and birthdate")
+    flag.Usage = func() {
+        fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-hex] [-redact policy] [file]\n", os.Args[0])
+        fmt.Fprintf(flag.CommandLine.Output(), "reads from stdin when no file (or '-') is given\n")
+        flag.PrintDefaults()
+    }
+    flag.Parse()
+
+    policy, err := common.ParseRedactPolicy(*redactPolicy)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "%v\n", err)
+        os.Exit(2)
+    }
+
+    input := io.Reader(os.Stdin)
+    if path := flag.Arg(0); path != "" && path != "-" {
+        file, err := os.Open(path)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "cannot open %v: %v\n", path, err)
+            os.Exit(2)
+        }
+        defer file.Close()
+        input = file
+    }
+
+    output := bufio.NewWriter(os.Stdout)
+    ok := inspect(bufio.NewReader(input), output, *showHex, policy)
+    output.Flush()
+
+    if !ok {
+        os.Exit(1)
+    }
+}
+
+// Decodifica todos los frames de r y los escribe en w, enmascarados
+//  segun policy. Devuelve false si algun frame estaba mal formado
+func inspect(r io.Reader, w io.Writer, showHex bool, policy common.RedactPolicy) bool {
+    decoder := common.NewFrameDecoder(r)
+    frames := 0
+
+    for {
+        frame, err := decoder.Next()
+        if frame != nil {
+            frames++
+            frame = policy.Frame(frame)
+            common.WriteFrame(w, frame)
+            if showHex {
+                fmt.Fprint(w, hex.Dump(frame.Raw))
+            }
+        }
+
+        if err == io.EOF {
+            fmt.Fprintf(w, "-- %d frames, %d bytes\n", frames, decoder.Offset())
+            return true
+        }
+
+        if err != nil {
+            fmt.Fprintf(w, "!! malformed frame: %v\n", err)
+            skipped, _ := io.Copy(io.Discard, r)
+            fmt.Fprintf(w, "-- %d frames, %d bytes decoded, %d bytes skipped\n", frames, decoder.Offset(), skipped)
+            return false
+        }
+    }
+}
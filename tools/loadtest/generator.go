@@ -0,0 +1,89 @@
+package main
+
+import (
+    "math/rand"
+    "strconv"
+    "sync/atomic"
+    "time"
+
+    "github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+)
+
+// Primer documento generado. Los documentos se asignan en orden a partir
+//  de este valor, por lo que son unicos entre todas las agencias
+const FIRST_DOCUMENT = 20000000
+
+var firstNames = []string{
+    "Santiago", "Lionel", "Agustin", "Emanuel", "Tiago", "Nicolás", "Mateo", "Benjamín",
+    "Valentina", "Martina", "Sofía", "Catalina", "Lucía", "Camila", "Julieta", "Florencia",
+    "Juan", "Pedro", "Tomás", "Facundo", "Joaquín", "Lautaro", "Bautista", "Franco",
+    "María", "Ana", "Paula", "Victoria", "Milagros", "Agustina", "Rocío", "Abril",
+}
+
+var surnames = []string{
+    "Lorca", "Zambrano", "Rivera", "González", "Rodríguez", "Gómez", "Fernández", "López",
+    "Díaz", "Martínez", "Pérez", "García", "Sánchez", "Romero", "Sosa", "Torres",
+    "Álvarez", "Ruiz", "Ramírez", "Flores", "Acosta", "Benítez", "Medina", "Herrera",
+}
+
+// Rango de fechas de nacimiento generadas
+var minBirthDate = time.Date(1940, time.January, 1, 0, 0, 0, 0, time.UTC)
+var maxBirthDate = time.Date(2005, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// BetGenerator genera apuestas sinteticas con el mismo formato que
+//  las de los archivos de datos
+type BetGenerator struct {
+    rnd          *rand.Rand
+    winningShare float64
+    documents    *uint64
+}
+
+// Crea un generador. documents es un contador compartido entre los
+//  generadores de todas las agencias para que los documentos no se repitan.
+// winningShare es la proporcion (entre 0 y 1) de apuestas al numero ganador
+func NewBetGenerator(seed int64, winningShare float64, documents *uint64) *BetGenerator {
+    return &BetGenerator{
+        rnd:          rand.New(rand.NewSource(seed)),
+        winningShare: winningShare,
+        documents:    documents,
+    }
+}
+
+// Genera una nueva apuesta
+func (g *BetGenerator) Next() common.Bet {
+    name := g.pick(firstNames)
+    if g.rnd.Intn(3) == 0 {
+        name += " " + g.pick(firstNames)
+    }
+
+    document := FIRST_DOCUMENT + atomic.AddUint64(g.documents, 1)
+
+    days := int(maxBirthDate.Sub(minBirthDate).Hours() / 24)
+    birthDate := minBirthDate.AddDate(0, 0, g.rnd.Intn(days+1))
+
+    return common.Bet{
+        Name:      name,
+        Surname:   g.pick(surnames),
+        Document:  strconv.FormatUint(document, 10),
+        BirthDate: birthDate.Format("2006-01-02"),
+        Number:    strconv.Itoa(g.number()),
+    }
+}
+
+// Elige el numero apostado: el ganador (common.LOTTERY_WINNER_NUMBER) con
+//  probabilidad winningShare, o cualquier otro numero de 4 cifras en caso
+//  contrario
+func (g *BetGenerator) number() int {
+    if g.rnd.Float64() < g.winningShare {
+        return common.LOTTERY_WINNER_NUMBER
+    }
+    number := g.rnd.Intn(9999)
+    if number >= common.LOTTERY_WINNER_NUMBER {
+        number++
+    }
+    return number
+}
+
+func (g *BetGenerator) pick(values []string) string {
+    return values[g.rnd.Intn(len(values))]
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "sort"
+    "strconv"
+    "sync"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+
+    "github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+)
+
+// Prueba de carga de la central de loteria.
+//
+// Simula M agencias concurrentes que envian apuestas sinteticas a traves
+//  del mismo NationalLotteryCenter que usa el cliente. Al terminar reporta
+//  el throughput, la latencia de confirmacion (p50/p99) de los batches y
+//  la cantidad de errores.
+//
+// Uso:
+//  loadtest [-target host:port] [-agencies m] [-bets n] [-batch-size b] [-winning-share s]
+func main() {
+    target := flag.String("target", "localhost:12345", "lottery center address")
+    agencies := flag.Int("agencies", 5, "number of concurrent simulated agencies")
+    firstID := flag.Int("first-id", 1, "id of the first simulated agency")
+    bets := flag.Int("bets", 10000, "bets sent by each agency")
+    batchSize := flag.Int("batch-size", 100, "bets per batch")
+    winningShare := flag.Float64("winning-share", 0.01, "share of bets (0 to 1) placed on the winning number")
+    seed := flag.Int64("seed", time.Now().UnixNano(), "random seed")
+    finish := flag.Bool("finish", true, "send the finish frame once an agency uploaded all its bets")
+    flag.Parse()
+
+    if *agencies <= 0 || *bets < 0 || *batchSize <= 0 || *winningShare < 0 || *winningShare > 1 {
+        flag.Usage()
+        os.Exit(2)
+    }
+
+    log.Infof("action: loadtest | result: in_progress | agencies: %v | bets: %v | batch_size: %v | winning_share: %v",
+        *agencies, *bets, *batchSize, *winningShare)
+
+    stats := &Stats{}
+    documents := uint64(0)
+    start := time.Now()
+
+    var wg sync.WaitGroup
+    for i := 0; i < *agencies; i++ {
+        agency := &Agency{
+            ID:        strconv.Itoa(*firstID + i),
+            Target:    *target,
+            Bets:      *bets,
+            BatchSize: *batchSize,
+            Finish:    *finish,
+            generator: NewBetGenerator(*seed+int64(i), *winningShare, &documents),
+            stats:     stats,
+        }
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            agency.Run()
+        }()
+    }
+    wg.Wait()
+
+    stats.Report(os.Stdout, time.Since(start))
+    if stats.errors > 0 {
+        os.Exit(1)
+    }
+}
+
+// Agency es una agencia simulada
+type Agency struct {
+    ID        string
+    Target    string
+    Bets      int
+    BatchSize int
+    Finish    bool
+    generator *BetGenerator
+    stats     *Stats
+}
+
+// Envia todas las apuestas de la agencia en batches. Ante un error
+//  la agencia se detiene, ya que la conexion queda en un estado invalido
+func (a *Agency) Run() {
+    center, err := common.DialNationalLotteryCenter(a.ID, a.Target)
+    if err != nil {
+        log.Errorf("action: connect | result: fail | client_id: %v | error: %v", a.ID, err)
+        a.stats.Error()
+        return
+    }
+    defer center.Close()
+
+    batch := make([]common.Bet, 0, a.BatchSize)
+    for sent := 0; sent < a.Bets; sent += len(batch) {
+        batch = batch[:0]
+        for len(batch) < a.BatchSize && sent+len(batch) < a.Bets {
+            batch = append(batch, a.generator.Next())
+        }
+
+        start := time.Now()
+        if err := center.UploadBatch(batch); err != nil {
+            log.Errorf("action: upload_batch | result: fail | client_id: %v | error: %v", a.ID, err)
+            a.stats.Error()
+            return
+        }
+        a.stats.Batch(len(batch), time.Since(start))
+    }
+
+    if a.Finish {
+        if err := center.Finish(); err != nil {
+            log.Errorf("action: finishing_connection | result: fail | client_id: %v | error: %v", a.ID, err)
+            a.stats.Error()
+            return
+        }
+    }
+    log.Debugf("action: agency_finished | result: success | client_id: %v", a.ID)
+}
+
+// Stats acumula los resultados de todas las agencias
+type Stats struct {
+    mu        sync.Mutex
+    bets      int
+    latencies []time.Duration
+    errors    int
+}
+
+// Registra un batch confirmado
+func (s *Stats) Batch(bets int, latency time.Duration) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.bets += bets
+    s.latencies = append(s.latencies, latency)
+}
+
+// Registra un error
+func (s *Stats) Error() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.errors++
+}
+
+// Escribe el reporte final de la prueba
+func (s *Stats) Report(w io.Writer, elapsed time.Duration) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+
+    fmt.Fprintf(w, "elapsed:     %v\n", elapsed.Round(time.Millisecond))
+    fmt.Fprintf(w, "bets:        %d\n", s.bets)
+    fmt.Fprintf(w, "batches:     %d\n", len(s.latencies))
+    fmt.Fprintf(w, "throughput:  %.1f bets/s\n", float64(s.bets)/elapsed.Seconds())
+    fmt.Fprintf(w, "ack p50:     %v\n", percentile(s.latencies, 0.50))
+    fmt.Fprintf(w, "ack p99:     %v\n", percentile(s.latencies, 0.99))
+    fmt.Fprintf(w, "errors:      %d\n", s.errors)
+}
+
+// Devuelve el percentil p (entre 0 y 1) de una lista ordenada
+func percentile(sorted []time.Duration, p float64) time.Duration {
+    if len(sorted) == 0 {
+        return 0
+    }
+    index := int(float64(len(sorted)-1) * p)
+    return sorted[index]
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+    "bytes"
+    "flag"
+    "fmt"
+    "io"
+    "net"
+    "os"
+    "path/filepath"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+
+    "github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+)
+
+// Proxy TCP entre las agencias y la central de loteria.
+//
+// Reenvia el trafico sin modificarlo y, en paralelo, decodifica ambas
+//  direcciones con el protocolo TLV del cliente. Cada frame se loguea con
+//  el id de la conexion y opcionalmente se guarda en un archivo de captura
+//  por conexion, que luego puede ser leido por el comando replay.
+//
+// Uso:
+//  proxy [-listen :12345] [-target server:12345] [-capture-dir dir] [-redact all] [-v]
+//
+// Los datos personales de los frames logueados se enmascaran segun
+//  -redact; los archivos de captura guardan siempre los bytes originales
+//  para que puedan reproducirse
+func main() {
+    listen := flag.String("listen", ":12345", "address where agencies connect")
+    target := flag.String("target", "server:12345", "lottery center address")
+    captureDir := flag.String("capture-dir", "", "directory where a capture file per connection is saved")
+    verbose := flag.Bool("v", false, "log the full frame tree instead of a one-line summary")
+    redactPolicy := flag.String("redact", "all", "personal data masked in the logged frames: all, none or a list of document, name and birthdate")
+    flag.Parse()
+
+    policy, err := common.ParseRedactPolicy(*redactPolicy)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "%v\n", err)
+        os.Exit(2)
+    }
+    redact = policy
+
+    log.SetFormatter(&log.TextFormatter{
+        TimestampFormat: "2006-01-02 15:04:05.000",
+        FullTimestamp:   true,
+    })
+    if *verbose {
+        log.SetLevel(log.DebugLevel)
+    }
+
+    listener, err := net.Listen("tcp", *listen)
+    if err != nil {
+        log.Fatalf("action: listen | result: fail | address: %v | error: %v", *listen, err)
+    }
+    log.Infof("action: listen | result: success | address: %v | target: %v", *listen, *target)
+
+    proxy := &Proxy{target: *target, captureDir: *captureDir}
+    for {
+        conn, err := listener.Accept()
+        if err != nil {
+            log.Errorf("action: accept_connections | result: fail | error: %v", err)
+            continue
+        }
+        go proxy.handle(conn)
+    }
+}
+
+// Proxy mantiene la configuracion compartida por todas las conexiones
+type Proxy struct {
+    target     string
+    captureDir string
+    lastID     uint64
+}
+
+// Atiende una conexion de una agencia hasta que ambas partes la cierran
+func (p *Proxy) handle(client net.Conn) {
+    id := atomic.AddUint64(&p.lastID, 1)
+    defer client.Close()
+
+    server, err := net.Dial("tcp", p.target)
+    if err != nil {
+        log.Errorf("action: connect | result: fail | conn: %v | target: %v | error: %v", id, p.target, err)
+        return
+    }
+    defer server.Close()
+    log.Infof("action: connect | result: success | conn: %v | client: %v", id, client.RemoteAddr())
+
+    capture, closeCapture := p.openCapture(id)
+    defer closeCapture()
+
+    var wg sync.WaitGroup
+    wg.Add(2)
+    go func() {
+        defer wg.Done()
+        forward(id, common.CLIENT_TO_SERVER, client, server, capture)
+    }()
+    go func() {
+        defer wg.Done()
+        forward(id, common.SERVER_TO_CLIENT, server, client, capture)
+    }()
+    wg.Wait()
+
+    log.Infof("action: disconnect | result: success | conn: %v", id)
+}
+
+// Crea el archivo de captura de la conexion, si se pidio guardar capturas
+func (p *Proxy) openCapture(id uint64) (*common.CaptureWriter, func()) {
+    if p.captureDir == "" {
+        return nil, func() {}
+    }
+
+    name := fmt.Sprintf("session-%s-%04d.cap", time.Now().Format("20060102-150405"), id)
+    file, err := os.Create(filepath.Join(p.captureDir, name))
+    if err != nil {
+        log.Errorf("action: create_capture | result: fail | conn: %v | error: %v", id, err)
+        return nil, func() {}
+    }
+
+    capture, err := common.NewCaptureWriter(file)
+    if err != nil {
+        log.Errorf("action: create_capture | result: fail | conn: %v | error: %v", id, err)
+        file.Close()
+        return nil, func() {}
+    }
+
+    log.Infof("action: create_capture | result: success | conn: %v | file: %v", id, file.Name())
+    return capture, func() { file.Close() }
+}
+
+// Copia de src a dst sin modificar los datos, decodificando en paralelo
+//  lo que se reenvia. Al terminar cierra la escritura de dst para que
+//  el otro extremo vea el fin de la comunicacion
+func forward(id uint64, direction byte, src net.Conn, dst net.Conn, capture *common.CaptureWriter) {
+    reader, writer := io.Pipe()
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        decode(id, direction, reader, capture)
+    }()
+
+    _, err := io.Copy(dst, io.TeeReader(src, writer))
+    if err != nil {
+        log.Debugf("action: forward | result: fail | conn: %v | direction: %v | error: %v", id, directionName(direction), err)
+    }
+    writer.Close()
+    <-done
+
+    if tcp, ok := dst.(*net.TCPConn); ok {
+        tcp.CloseWrite()
+    } else {
+        dst.Close()
+    }
+}
+
+// Loguea y captura cada frame que pasa en una direccion. Si un frame esta
+//  mal formado se deja de decodificar, pero se sigue consumiendo el pipe
+//  para no frenar el reenvio
+func decode(id uint64, direction byte, r io.Reader, capture *common.CaptureWriter) {
+    decoder := common.NewFrameDecoder(r)
+    for {
+        frame, err := decoder.Next()
+        if frame != nil {
+            logFrame(id, direction, frame)
+            if capture != nil {
+                record := common.CaptureRecord{Direction: direction, Time: time.Now(), Data: frame.Raw}
+                if err := capture.Write(record); err != nil {
+                    log.Errorf("action: capture | result: fail | conn: %v | error: %v", id, err)
+                    capture = nil
+                }
+            }
+        }
+
+        if err == io.EOF {
+            return
+        }
+        if err != nil {
+            log.Warnf("action: decode | result: fail | conn: %v | direction: %v | error: %v", id, directionName(direction), err)
+            io.Copy(io.Discard, r)
+            return
+        }
+    }
+}
+
+// Politica de enmascarado de los frames logueados
+var redact = common.REDACT_ALL
+
+func logFrame(id uint64, direction byte, frame *common.Frame) {
+    frame = redact.Frame(frame)
+    if log.IsLevelEnabled(log.DebugLevel) {
+        tree := &bytes.Buffer{}
+        common.WriteFrame(tree, frame)
+        log.Debugf("action: frame | result: success | conn: %v | direction: %v | bytes: %v\n%s",
+            id, directionName(direction), len(frame.Raw), tree)
+        return
+    }
+    log.Infof("action: frame | result: success | conn: %v | direction: %v | bytes: %v | frame: %v",
+        id, directionName(direction), len(frame.Raw), frame)
+}
+
+func directionName(direction byte) string {
+    if direction == common.CLIENT_TO_SERVER {
+        return "client->server"
+    }
+    return "server->client"
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+    "bytes"
+    "flag"
+    "fmt"
+    "net"
+    "os"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+
+    "github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+)
+
+// Reproduce sesiones de agencias grabadas por el proxy contra un servidor.
+//
+// Cada archivo de captura es una conexion: se reenvian los frames que
+//  el cliente envio (C) y se comparan las respuestas del servidor con
+//  las grabadas (S). Los archivos se reproducen en el orden recibido.
+//
+// Uso:
+//  replay [-target host:port] [-timing original|fast] [-speed x] captura...
+func main() {
+    target := flag.String("target", "localhost:12345", "lottery center address")
+    timing := flag.String("timing", "original", "'original' keeps the recorded pauses, 'fast' sends as fast as possible")
+    speed := flag.Float64("speed", 1, "speed multiplier applied to the recorded pauses (original timing only)")
+    timeout := flag.Duration("timeout", 10*time.Second, "maximum time to wait for each server response")
+    flag.Usage = func() {
+        fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] capture...\n", os.Args[0])
+        flag.PrintDefaults()
+    }
+    flag.Parse()
+
+    if flag.NArg() == 0 || *speed <= 0 || (*timing != "original" && *timing != "fast") {
+        flag.Usage()
+        os.Exit(2)
+    }
+
+    replayer := &Replayer{
+        target:  *target,
+        fast:    *timing == "fast",
+        speed:   *speed,
+        timeout: *timeout,
+    }
+
+    mismatches := 0
+    for _, path := range flag.Args() {
+        result, err := replayer.ReplayFile(path)
+        if err != nil {
+            log.Errorf("action: replay | result: fail | file: %v | error: %v", path, err)
+            mismatches++
+            continue
+        }
+        log.Infof("action: replay | result: success | file: %v | sent: %v | matched: %v | mismatched: %v",
+            path, result.sent, result.matched, result.mismatched)
+        mismatches += result.mismatched
+    }
+
+    if mismatches > 0 {
+        os.Exit(1)
+    }
+}
+
+// Replayer reproduce capturas contra un servidor
+type Replayer struct {
+    target  string
+    fast    bool
+    speed   float64
+    timeout time.Duration
+}
+
+// Resultado de reproducir una sesion
+type replayResult struct {
+    sent       int
+    matched    int
+    mismatched int
+}
+
+// Reproduce una captura completa en una nueva conexion con el servidor
+func (r *Replayer) ReplayFile(path string) (replayResult, error) {
+    result := replayResult{}
+
+    file, err := os.Open(path)
+    if err != nil {
+        return result, err
+    }
+    records, err := common.ReadCapture(file)
+    file.Close()
+    if err != nil {
+        return result, err
+    }
+    if len(records) == 0 {
+        return result, nil
+    }
+
+    conn, err := net.Dial("tcp", r.target)
+    if err != nil {
+        return result, err
+    }
+    defer conn.Close()
+
+    decoder := common.NewFrameDecoder(conn)
+    previous := records[0].Time
+
+    for i, record := range records {
+        if record.Direction == common.CLIENT_TO_SERVER {
+            r.wait(record.Time.Sub(previous))
+            if _, err := conn.Write(record.Data); err != nil {
+                return result, fmt.Errorf("cannot send record %d: %v", i, err)
+            }
+            result.sent++
+            previous = record.Time
+            continue
+        }
+
+        // Respuesta del servidor: se compara con lo grabado
+        previous = record.Time
+        conn.SetReadDeadline(time.Now().Add(r.timeout))
+        frame, err := decoder.Next()
+        if err != nil {
+            log.Warnf("action: compare_response | result: fail | record: %v | expected: %v | error: %v",
+                i, describe(record.Data), err)
+            result.mismatched += countResponses(records[i:])
+            return result, nil
+        }
+
+        if bytes.Equal(frame.Raw, record.Data) {
+            result.matched++
+            log.Debugf("action: compare_response | result: success | record: %v | frame: %v", i, frame)
+        } else {
+            result.mismatched++
+            log.Warnf("action: compare_response | result: mismatch | record: %v | expected: %v | got: %v",
+                i, describe(record.Data), frame)
+        }
+    }
+
+    return result, nil
+}
+
+// Espera la pausa grabada entre dos frames segun el modo de reproduccion
+func (r *Replayer) wait(pause time.Duration) {
+    if r.fast || pause <= 0 {
+        return
+    }
+    time.Sleep(time.Duration(float64(pause) / r.speed))
+}
+
+// Cuenta las respuestas del servidor que quedan por comparar
+func countResponses(records []common.CaptureRecord) int {
+    count := 0
+    for _, record := range records {
+        if record.Direction == common.SERVER_TO_CLIENT {
+            count++
+        }
+    }
+    return count
+}
+
+// Describe en una linea el frame contenido en data
+func describe(data []byte) string {
+    frame, err := common.NewFrameDecoder(bytes.NewReader(data)).Next()
+    if frame == nil {
+        return fmt.Sprintf("undecodable frame: %v", err)
+    }
+    return frame.String()
+}